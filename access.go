@@ -0,0 +1,136 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// AccessT restricts use to particular users and groups.  Deny takes
+// precedence over allow, and if there is anything in either allow
+// list, then only those users and groups are allowed.
+type AccessT struct {
+	AllowUsers  []string `toml:"allow-users"`
+	AllowGroups []string `toml:"allow-groups"`
+	DenyUsers   []string `toml:"deny-users"`
+	DenyGroups  []string `toml:"deny-groups"`
+}
+
+// getent looks up groups through NSS, which os/user cannot do without cgo
+const getent = "/usr/bin/getent"
+
+// userGroups returns the names of all groups of which u is a member,
+// and is a variable only so that tests may fake group membership.
+var userGroups = func(u *user.User) ([]string, error) {
+	gids, err := groupIds(u)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, gid := range gids {
+		name, err := groupName(gid)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// groupIds returns the ids of all groups of which u is a member.  For the
+// user running rkt-run, these are the groups of the process, as granted
+// at login, which include those from LDAP or SSSD.  For any other user,
+// they are only those found by os/user, which without cgo means only
+// those in /etc/group.
+func groupIds(u *user.User) ([]string, error) {
+	if u.Uid != strconv.Itoa(startUid) {
+		return u.GroupIds()
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		return nil, err
+	}
+	gids := []string{u.Gid}
+	for _, group := range groups {
+		gid := strconv.Itoa(group)
+		if gid != u.Gid {
+			gids = append(gids, gid)
+		}
+	}
+	return gids, nil
+}
+
+// groupName returns the name of the group, looking it up with getent
+// if it is not in /etc/group.  A group whose name cannot be found is an
+// error, lest deny-groups be silently ineffective.
+func groupName(gid string) (string, error) {
+	g, err := user.LookupGroupId(gid)
+	if err == nil {
+		return g.Name, nil
+	}
+	cmd := exec.Command(getent, "group", gid)
+	cmd.Env = []string{}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to find group %s: %v", gid, err)
+	}
+	// name:password:gid:members
+	fields := strings.SplitN(strings.TrimSpace(string(out)), ":", 2)
+	if fields[0] == "" {
+		return "", fmt.Errorf("failed to find group %s", gid)
+	}
+	return fields[0], nil
+}
+
+// isEmpty returns whether there is no access control
+func (a *AccessT) isEmpty() bool {
+	return len(a.AllowUsers) == 0 && len(a.AllowGroups) == 0 && len(a.DenyUsers) == 0 && len(a.DenyGroups) == 0
+}
+
+func containsAny(list []string, names ...string) bool {
+	for _, s := range list {
+		for _, name := range names {
+			if s == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allows returns whether u is allowed access
+func (a *AccessT) allows(u *user.User) (bool, error) {
+	var groups []string
+	if len(a.AllowGroups) > 0 || len(a.DenyGroups) > 0 {
+		var err error
+		groups, err = userGroups(u)
+		if err != nil {
+			return false, fmt.Errorf("failed to get groups for %s: %v", u.Username, err)
+		}
+	}
+
+	if containsAny(a.DenyUsers, u.Username) || containsAny(a.DenyGroups, groups...) {
+		return false, nil
+	}
+	if len(a.AllowUsers) == 0 && len(a.AllowGroups) == 0 {
+		return true, nil
+	}
+	return containsAny(a.AllowUsers, u.Username) || containsAny(a.AllowGroups, groups...), nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+)
+
+func TestAccessAllows(t *testing.T) {
+	savedUserGroups := userGroups
+	defer func() { userGroups = savedUserGroups }()
+	userGroups = func(u *user.User) ([]string, error) {
+		return []string{"staff", "matlab"}, nil
+	}
+	u := &user.User{Username: "alice"}
+
+	tests := []struct {
+		name     string
+		access   AccessT
+		expected bool
+	}{
+		{"empty", AccessT{}, true},
+		{"allow user", AccessT{AllowUsers: []string{"bob", "alice"}}, true},
+		{"allow other user", AccessT{AllowUsers: []string{"bob"}}, false},
+		{"allow group", AccessT{AllowGroups: []string{"matlab"}}, true},
+		{"allow other group", AccessT{AllowGroups: []string{"stata"}}, false},
+		{"allow other user or group", AccessT{AllowUsers: []string{"bob"}, AllowGroups: []string{"staff"}}, true},
+		{"deny user", AccessT{DenyUsers: []string{"alice"}}, false},
+		{"deny other user", AccessT{DenyUsers: []string{"bob"}}, true},
+		{"deny group", AccessT{DenyGroups: []string{"staff"}}, false},
+		{"deny overrides allow", AccessT{AllowUsers: []string{"alice"}, DenyGroups: []string{"staff"}}, false},
+	}
+	for _, test := range tests {
+		allowed, err := test.access.allows(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != test.expected {
+			t.Errorf("%s: allowed %v, expected %v", test.name, allowed, test.expected)
+		}
+	}
+}
+
+func TestGroupIdsFromProcess(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, err := os.Getgroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gids, err := groupIds(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gids) == 0 || gids[0] != u.Gid {
+		t.Errorf("group ids %v, expected primary group %s first", gids, u.Gid)
+	}
+	for _, group := range groups {
+		if !containsAny(gids, strconv.Itoa(group)) {
+			t.Errorf("group ids %v, missing process group %d", gids, group)
+		}
+	}
+}
+
+func TestGroupNameUnknown(t *testing.T) {
+	_, err := groupName("2147483000")
+	if err == nil {
+		t.Errorf("expected error for unknown group")
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"os"
+	"syscall"
+	"time"
+)
+
+const auditTag = "rkt-run"
+
+// worker pod usage, for the audit record
+const NewWorkerPod = "new"
+const ReusedWorkerPod = "reused"
+
+// AuditT configures where audit records are written
+type AuditT struct {
+	Syslog bool
+	File   string
+}
+
+// AuditRecord is written once for each invocation of rkt-run,
+// whether it ran a container, failed, or was denied.
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Uid        string    `json:"uid"`
+	Alias      string    `json:"alias,omitempty"`
+	Image      string    `json:"image,omitempty"`
+	Exec       string    `json:"exec,omitempty"`
+	Volumes    []string  `json:"volumes,omitempty"`
+	Cwd        string    `json:"cwd"`
+	PodUUID    string    `json:"pod-uuid,omitempty"`
+	Worker     string    `json:"worker,omitempty"`
+	Denied     string    `json:"denied,omitempty"`
+	ExitStatus int       `json:"exit-status"`
+	Duration   float64   `json:"duration"`
+}
+
+func (a *AuditT) isEnabled() bool {
+	return a.Syslog || a.File != ""
+}
+
+// write writes the record to each configured destination
+func (a *AuditT) write(rec *AuditRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if a.Syslog {
+		priority := syslog.LOG_AUTHPRIV | syslog.LOG_INFO
+		if rec.Denied != "" {
+			priority = syslog.LOG_AUTHPRIV | syslog.LOG_WARNING
+		}
+		w, err := syslog.New(priority, auditTag)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(line)
+		w.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.File != "" {
+		err = appendAuditFile(a.File, line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendAuditFile appends the line to the audit file, which must be
+// owned by us, i.e. root, and writable by no-one else.
+func appendAuditFile(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || int(stat.Uid) != syscall.Geteuid() || info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s has unsafe ownership or permissions", path)
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,163 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// auditConfig enables the audit file, returning its path.
+func (h *harness) auditConfig(config string) string {
+	path := filepath.Join(filepath.Dir(h.root), "audit.log")
+	h.writeConfig(fmt.Sprintf("%s\n[audit]\nfile = %q\n", config, path))
+	return path
+}
+
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+	lines, err := readLines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []AuditRecord
+	for _, line := range lines {
+		var rec AuditRecord
+		err = json.Unmarshal([]byte(line), &rec)
+		if err != nil {
+			t.Fatalf("bad audit record %s: %v", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestAuditRun(t *testing.T) {
+	h := newHarness(t)
+	path := h.auditConfig(testConfig)
+	h.script("run", "", 3)
+
+	h.rktRun("--volume", "dataset", "grep", "needle")
+	h.rktRun("--dry-run", "grep", "needle")
+
+	records := readAuditRecords(t, path)
+	if len(records) != 1 {
+		t.Fatalf("expected one audit record, got %v", records)
+	}
+	rec := records[0]
+	if rec.User != h.user.Username || rec.Uid != h.user.Uid ||
+		rec.Alias != "busybox_" || rec.Image != "example.com/tools/busybox:1.0" || rec.Exec != "/bin/grep" ||
+		!reflect.DeepEqual(rec.Volumes, []string{"dataset"}) || rec.Cwd != h.workDir ||
+		rec.PodUUID != fakeUUID || rec.Worker != "" || rec.Denied != "" || rec.ExitStatus != 3 {
+		t.Errorf("unexpected audit record %+v", rec)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("audit file mode %v", info.Mode())
+	}
+}
+
+func TestAuditWorker(t *testing.T) {
+	h := newHarness(t)
+	path := h.auditConfig(testWorkerConfig)
+	h.script("status", "state=running\n", 0)
+
+	stderr, status := h.rktRun("grep", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	h.script("list", listLine(fakeUUID, WORKER_APPNAME_PREFIX+h.user.Username, "example.com/tools/busybox:1.0", "running", ""), 0)
+	h.script("cat-manifest", podManifest(h.user.Uid), 0)
+	stderr, status = h.rktRun("grep", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+
+	records := readAuditRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("expected two audit records, got %v", records)
+	}
+	for i, worker := range []string{NewWorkerPod, ReusedWorkerPod} {
+		if records[i].Worker != worker || records[i].PodUUID != fakeUUID {
+			t.Errorf("audit record %d %+v, expected %s worker pod", i, records[i], worker)
+		}
+	}
+}
+
+func TestAuditDenied(t *testing.T) {
+	h := newHarness(t)
+	path := h.auditConfig("restrict-images = true\n" + testConfig + "allow-groups = [\"licensed\"]\n")
+	h.environ = append(h.environ, testGroupsEnv+"=users")
+
+	h.rktRun("grep", "needle")
+	h.rktRun("busybox")
+
+	records := readAuditRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("expected two audit records, got %v", records)
+	}
+	expected := []string{
+		"alias busybox_ not permitted for user " + h.user.Username,
+		"restrict-images in force, only aliased images allowed",
+	}
+	for i, denied := range expected {
+		if records[i].Denied != denied || records[i].ExitStatus != 1 {
+			t.Errorf("audit record %d %+v, expected denied %s", i, records[i], denied)
+		}
+	}
+}
+
+func TestAuditBadRequest(t *testing.T) {
+	h := newHarness(t)
+	path := h.auditConfig(testConfig)
+
+	h.rktRun("--exec", "/bin/ls", "grep")
+	h.rktRun("--volume", "nonexistent", "grep")
+	h.rktRun("--dry-run", "--exec", "/bin/ls", "grep")
+
+	records := readAuditRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("expected two audit records, got %v", records)
+	}
+	for i, rec := range records {
+		if rec.User != h.user.Username || rec.ExitStatus != 1 || rec.Denied != "" {
+			t.Errorf("unexpected audit record %d %+v", i, rec)
+		}
+	}
+}
+
+func TestAuditSignal(t *testing.T) {
+	h := newHarness(t)
+	path := h.auditConfig(testWorkerConfig)
+
+	// terminated while looking for a worker pod, before running anything
+	status, _ := h.signalRktRun("list", nil, []syscall.Signal{syscall.SIGTERM}, "grep", "needle")
+	if expected := 128 + int(syscall.SIGTERM); status != expected {
+		t.Errorf("exit status %d, expected %d", status, expected)
+	}
+
+	records := readAuditRecords(t, path)
+	if len(records) != 1 || records[0].ExitStatus != 128+int(syscall.SIGTERM) {
+		t.Errorf("expected audit record for signal, got %+v", records)
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ConfigProblem is a problem found in a config file, at a line
+// number if known, otherwise zero.
+type ConfigProblem struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (p *ConfigProblem) Error() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", p.Path, p.Line, p.Message)
+	}
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// configCheckerT accumulates problems found in the config
+type configCheckerT struct {
+	c        *configT
+	path     string
+	problems []*ConfigProblem
+}
+
+func newConfigChecker(path string, c *configT) *configCheckerT {
+	return &configCheckerT{c: c, path: path}
+}
+
+// source returns the file in which the key was defined
+func (ch *configCheckerT) source(key toml.Key) string {
+	if len(key) > 1 {
+		source := ch.c.Source(key[0], key[1])
+		if source != "" {
+			return source
+		}
+	}
+	return ch.path
+}
+
+// reportf records a problem with the config at the key
+func (ch *configCheckerT) reportf(key toml.Key, format string, args ...interface{}) {
+	ch.reportAtf(ch.source(key), key, format, args...)
+}
+
+// reportAtf records a problem with the config at the key in the file path
+func (ch *configCheckerT) reportAtf(path string, key toml.Key, format string, args ...interface{}) {
+	ch.problems = append(ch.problems, &ConfigProblem{
+		Path:    path,
+		Line:    keyLine(path, key),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (ch *configCheckerT) firstProblem() error {
+	if len(ch.problems) > 0 {
+		return ch.problems[0]
+	}
+	return nil
+}
+
+// unquoteKey removes whitespace and any quotes from a key or table name
+func unquoteKey(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		if s[0] == '"' {
+			unquoted, err := strconv.Unquote(s)
+			if err == nil {
+				return unquoted
+			}
+		}
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// splitTableName splits a table name into its dotted components
+func splitTableName(s string) toml.Key {
+	var key toml.Key
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == '.':
+			key = append(key, unquoteKey(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(key, unquoteKey(s[start:]))
+}
+
+func keysEqual(a, b toml.Key) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// keyLine returns the line number at which the key is defined in
+// the TOML file, or zero if it can't be found.  This is approximate,
+// being a simple line-by-line scan.
+func keyLine(path string, key toml.Key) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	var table toml.Key
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#':
+			continue
+
+		case line[0] == '[':
+			end := strings.LastIndexByte(line, ']')
+			if end < 0 {
+				continue
+			}
+			table = splitTableName(strings.Trim(line[:end+1], "[]"))
+			if keysEqual(table, key) {
+				return lineno
+			}
+
+		default:
+			eq := strings.IndexByte(line, '=')
+			if eq < 0 {
+				continue
+			}
+			lineKey := append(append(toml.Key{}, table...), unquoteKey(line[:eq]))
+			if keysEqual(lineKey, key) {
+				return lineno
+			}
+		}
+	}
+	return 0
+}
+
+// checkTemplates reports template errors in all fragments, and returns
+// the fragments expanded as far as possible.
+func (r *RunnerT) checkTemplates(ch *configCheckerT, vars map[string]string) *fragmentsT {
+	var f fragmentsT
+	getFragments(&r.config, vars, &f, func(key toml.Key, err error) {
+		ch.reportf(key, "%v", err)
+	})
+	return &f
+}
+
+// checkVolumes reports host volumes whose source does not exist.
+// Sources expanded from templates are not checked, since that would
+// let any user probe for the existence of paths as root.
+func (r *RunnerT) checkVolumes(ch *configCheckerT, f *fragmentsT) {
+	for _, name := range f.VolumeOrder {
+		if strings.Contains(r.config.Volume[name].Volume, "{{") {
+			continue
+		}
+		params := make(map[string]string)
+		for _, param := range strings.Split(f.Volume[name].Volume, ",") {
+			UpdateEnviron(params, param)
+		}
+		if params["kind"] == "host" {
+			source := params["source"]
+			switch {
+			case source == "":
+				ch.reportf(toml.Key{VolumeTable, name, "volume"}, "host volume %s has no source", name)
+			case !exists(source):
+				ch.reportf(toml.Key{VolumeTable, name, "volume"}, "host volume %s source %s does not exist", name, source)
+			}
+		}
+	}
+}
+
+// checkConfig reports all problems with the config to w, returning
+// an error if there were any.
+func (r *RunnerT) checkConfig(w io.Writer, path string, vars map[string]string) error {
+	err := readConfig(path, &r.config)
+	if err != nil {
+		return err
+	}
+
+	ch := newConfigChecker(path, &r.config)
+	for _, key := range r.config.undecoded {
+		ch.reportf(key, "unknown key %s", key)
+	}
+
+	_, err = NewRuntime(&r.config)
+	if err != nil {
+		ch.reportf(toml.Key{"runtime"}, "%v", err)
+	}
+
+	r.config.validate(ch)
+
+	r.registerAllAliases(ioutil.Discard, false, func(key toml.Key, err error) {
+		ch.reportf(key, "%v", err)
+	})
+
+	f := r.checkTemplates(ch, vars)
+	r.checkVolumes(ch, f)
+
+	for _, problem := range ch.problems {
+		fmt.Fprintf(w, "%v\n", problem)
+	}
+	if len(ch.problems) > 0 {
+		return fmt.Errorf("%d problems found in %s", len(ch.problems), path)
+	}
+	return nil
+}
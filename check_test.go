@@ -0,0 +1,87 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const problemConfig = `rkt = "/usr/bin/rkt"
+include = ["rktrunner.d/*.toml"]
+exec-slave-dir = "/nonexistent/rktrunner"
+
+[volume.home]
+volume = "kind=host,source={{.HomeDir}"
+mount = "target=/home/{{.Username}}"
+
+[volume.dataset]
+volume = "kind=host,source=/nonexistent/dataset"
+on_request = true
+
+[volume.scratch]
+volume = "kind=host,source=/nonexistent/{{.Username}}"
+mount = "target=/scratch"
+
+[alias.samtools_]
+image = "quay.io/biocontainers/samtools:1.4.1--0"
+exec = ["samtools"]
+`
+
+const problemInclude = `
+worker-pods = true
+
+[alias.samtools-old_]
+image = "quay.io/biocontainers/samtools:0.1.19--1"
+exec = ["/usr/local/bin/samtools"]
+exec-slave-dir = "/usr/lib/rktrunner"
+`
+
+func TestCheckConfig(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"rktrunner.toml":            problemConfig,
+		"rktrunner.d/samtools.toml": problemInclude,
+	})
+	path := filepath.Join(dir, "rktrunner.toml")
+
+	var r RunnerT
+	var out bytes.Buffer
+	err := r.checkConfig(&out, path, map[string]string{"HomeDir": "/home/user", "Username": "user"})
+	if err == nil {
+		t.Fatalf("expected problems")
+	}
+
+	expected := []string{
+		"$DIR/rktrunner.toml:11: unknown key volume.dataset.on_request",
+		"$DIR/rktrunner.d/samtools.toml:2: worker-pods not allowed in included file",
+		"$DIR/rktrunner.d/samtools.toml:7: alias.samtools-old_.exec-slave-dir not allowed in included file",
+		"$DIR/rktrunner.toml:3: stat /nonexistent/rktrunner/rkt-run-slave: no such file or directory",
+		"$DIR/rktrunner.toml:19: duplicate alias: samtools, from alias samtools-old_ in $DIR/rktrunner.d/samtools.toml and alias samtools_ in $DIR/rktrunner.toml",
+		"$DIR/rktrunner.toml:6: ",
+		"$DIR/rktrunner.toml:10: host volume dataset source /nonexistent/dataset does not exist",
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("reported:\n%s\nexpected %d problems", out.String(), len(expected))
+	}
+	for i, line := range lines {
+		prefix := strings.Replace(expected[i], "$DIR", dir, -1)
+		if !strings.HasPrefix(line, prefix) {
+			t.Errorf("problem %d: %s\nexpected: %s", i, line, prefix)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tesujimath/rktrunner/internal/fakerkt"
+)
+
+func main() {
+	dir := os.Getenv(fakerkt.DirEnv)
+	if dir == "" {
+		fmt.Fprintf(os.Stderr, "fake-rkt: %s not set\n", fakerkt.DirEnv)
+		os.Exit(254)
+	}
+	os.Exit(fakerkt.Main(dir, os.Args))
+}
@@ -23,7 +23,7 @@ import (
 )
 
 func die(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "get-worker: %s\n", fmt.Sprintf(format, args))
+	fmt.Fprintf(os.Stderr, "get-worker: %s\n", fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
 
@@ -18,14 +18,17 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"runtime"
 	"syscall"
 
 	"github.com/droundy/goopt"
 	"github.com/tesujimath/rktrunner"
+	"golang.org/x/sys/unix"
 )
 
 func die(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "rkt-run-slave: %s\n", fmt.Sprintf(format, args))
+	fmt.Fprintf(os.Stderr, "rkt-run-slave: %s\n", fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
 
@@ -43,10 +46,28 @@ func waitForever() error {
 	return nil
 }
 
+// setrlimit lowers both soft and hard limits, but never raises them
+func setrlimit(resource int, limit int) error {
+	var rlimit unix.Rlimit
+	err := unix.Getrlimit(resource, &rlimit)
+	if err != nil {
+		return err
+	}
+	if uint64(limit) < rlimit.Max {
+		rlimit.Max = uint64(limit)
+	}
+	if uint64(limit) < rlimit.Cur {
+		rlimit.Cur = uint64(limit)
+	}
+	return unix.Setrlimit(resource, &rlimit)
+}
+
 func main() {
 	wait := goopt.Flag([]string{"--wait"}, []string{}, "wait forever", "")
 	cwd := goopt.String([]string{"--cwd"}, "", "run with current working directory")
 	setenvs := goopt.Strings([]string{"--set-env"}, "", "environment variable")
+	pidsLimit := goopt.Int([]string{"--pids-limit"}, 0, "limit number of processes")
+	nofile := goopt.Int([]string{"--nofile"}, 0, "limit number of open files")
 	goopt.RequireOrder = true
 	goopt.Author = "Simon Guest <simon.guest@tesujimath.org>"
 	goopt.Summary = "Slave program to run within rkt container"
@@ -72,6 +93,20 @@ func main() {
 		}
 	}
 
+	// resource limits, inherited by the command
+	if *pidsLimit > 0 {
+		err := setrlimit(unix.RLIMIT_NPROC, *pidsLimit)
+		if err != nil {
+			die("failed to set pids-limit: %v", err)
+		}
+	}
+	if *nofile > 0 {
+		err := setrlimit(unix.RLIMIT_NOFILE, *nofile)
+		if err != nil {
+			die("failed to set nofile: %v", err)
+		}
+	}
+
 	// environment
 	env := os.Environ()
 	if *setenvs != nil {
@@ -87,11 +122,48 @@ func main() {
 		if err != nil {
 			die("%v PATH=%s", err, os.Getenv("PATH"))
 		}
-		err = syscall.Exec(argv0, args, env)
-		if err != nil {
-			die("%v", err)
-		}
+		os.Exit(run(argv0, args, env))
 	} else {
 		die("warning: %s", "nothing to execute")
 	}
 }
+
+// run runs the command as a child, rather than exec'ing it, so that
+// signals forwarded by rkt-run may be relayed, and returns its exit status.
+// The child is killed if we die, and we die with our parent, rkt enter,
+// so that when rkt-run kills that, after the signal grace period or on
+// timeout, the command is not left running in the worker pod.
+func run(argv0 string, args []string, env []string) int {
+	// the parent death signal is tied to the thread which starts the child
+	runtime.LockOSThread()
+	err := unix.Prctl(unix.PR_SET_PDEATHSIG, uintptr(unix.SIGKILL), 0, 0, 0)
+	if err != nil {
+		die("failed to set parent death signal: %v", err)
+	}
+
+	cmd := exec.Command(argv0, args[1:]...)
+	cmd.Args[0] = args[0]
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+
+	c := make(chan os.Signal, 1)
+	// SIGINT is disregarded, since the child receives it from the terminal
+	signal.Notify(c, append([]os.Signal{syscall.SIGINT}, rktrunner.ForwardedSignals...)...)
+
+	err = cmd.Start()
+	if err != nil {
+		die("%v", err)
+	}
+	go func() {
+		for s := range c {
+			if s != syscall.SIGINT {
+				cmd.Process.Signal(s)
+			}
+		}
+	}()
+
+	return rktrunner.ExitStatus(cmd.Wait())
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as rkt-run-slave, and as rkt enter, which
+// runs rkt-run-slave as its child.
+const testModeEnv = "RKT_RUN_SLAVE_TEST_MODE"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(testModeEnv) {
+	case "slave":
+		os.Args[0] = "rkt-run-slave"
+		main()
+	case "enter":
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.Env = append(os.Environ(), testModeEnv+"=slave")
+		cmd.Stderr = os.Stderr
+		cmd.Run()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// alive returns whether the process exists, and is not a zombie
+func alive(pid int) bool {
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// TestKillEnter checks that killing rkt enter, as rkt-run does after the
+// signal grace period or on timeout, kills the command in the worker pod.
+func TestKillEnter(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	enter := exec.Command(os.Args[0], "sh", "-c", "echo $$ >"+pidFile+"; exec sleep 60")
+	enter.Env = append(os.Environ(), testModeEnv+"=enter")
+	err := enter.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pid int
+	for i := 0; i < 100 && pid == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+		b, _ := ioutil.ReadFile(pidFile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	if pid == 0 {
+		enter.Process.Kill()
+		t.Fatal("command not started")
+	}
+
+	enter.Process.Signal(syscall.SIGKILL)
+	enter.Wait()
+	for i := 0; i < 100 && alive(pid); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if alive(pid) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		t.Errorf("command left running after rkt enter was killed")
+	}
+}
@@ -18,7 +18,6 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"os/signal"
 	"syscall"
 
 	"github.com/tesujimath/rktrunner"
@@ -30,27 +29,15 @@ func die(format string, args ...interface{}) {
 }
 
 func main() {
-	r, err := rktrunner.NewRunner("/etc/rktrunner.toml")
+	r, err := rktrunner.NewRunner(rktrunner.DefaultConfigFile)
 	// for testing:
 	// r, err := rktrunner.NewRunner("/home/guestsi/go/src/github.com/tesujimath/rktrunner/examples/rktrunner-biocontainers.toml")
 	if err != nil {
 		die("%v", err)
 	}
 
-	// ensure we cleanup on interrupt
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM)
-	go func() {
-		for {
-			s := <-c
-			// We can't simply signal.Ignore(syscall.SIGINT), as that would
-			// inhibit child processes from receiving it, so we disregard it here.
-			if s != syscall.SIGINT {
-				r.RemoveTempFiles()
-				os.Exit(1)
-			}
-		}
-	}()
+	// forward signals to the container, and ensure we cleanup
+	r.HandleSignals()
 
 	// set real uid same as effective
 	err = syscall.Setreuid(syscall.Geteuid(), syscall.Geteuid())
@@ -60,10 +47,14 @@ func main() {
 
 	err = r.Execute()
 	if err != nil {
-		_, isExitErr := err.(*exec.ExitError)
-		if isExitErr {
-			os.Exit(1)
-		} else {
+		switch err.(type) {
+		case *exec.ExitError:
+			// propagate the exit status of the containerised command
+			os.Exit(rktrunner.ExitStatus(err))
+		case *rktrunner.TimeoutError:
+			fmt.Fprintf(os.Stderr, "rkt-run: %v\n", err)
+			os.Exit(rktrunner.ExitStatus(err))
+		default:
 			die("failed: %v", err)
 		}
 	}
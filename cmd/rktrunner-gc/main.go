@@ -17,9 +17,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"os/exec"
-	"strings"
-	"syscall"
 	"time"
 
 	"github.com/droundy/goopt"
@@ -27,40 +24,13 @@ import (
 )
 
 func die(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "rktrunner-gc: %s\n", fmt.Sprintf(format, args))
+	fmt.Fprintf(os.Stderr, "rktrunner-gc: %s\n", fmt.Sprintf(format, args...))
 	os.Exit(1)
 }
 
-func lockPod(uuid string) (*os.File, error) {
-	podlock, err := os.Open(rktrunner.WorkerPodDir(uuid))
-	if err != nil {
-		return nil, err
-	}
-	err = syscall.Flock(int(podlock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
-	if err != nil {
-		podlock.Close()
-		return nil, err
-	}
-	return podlock, nil
-}
-
-func stopPod(pod *rktrunner.VisitedPod, podState string) error {
-	args := []string{"rkt", "stop", pod.UUID}
-	argv0, err := exec.LookPath(args[0])
-	if err != nil {
-		die("%v PATH=%s", err, os.Getenv("PATH"))
-	}
-	cmd := exec.Command(argv0, args[1:]...)
-	err = cmd.Run()
-	if err == nil {
-		fmt.Fprintf(os.Stderr, "stop %s %s\n", podState, pod)
-	}
-	return err
-}
-
 func main() {
 	dryRun := goopt.Flag([]string{"--dry-run"}, []string{}, "don't execute anything", "")
-	gracePeriodRaw := goopt.String([]string{"--grace-period"}, "", "duration to wait before collecting idle worker pods")
+	gracePeriodRaw := goopt.String([]string{"--grace-period"}, "", "how long worker pods must be idle before being collected")
 	goopt.RequireOrder = true
 	goopt.Author = "Simon Guest <simon.guest@tesujimath.org>"
 	goopt.Summary = "rktrunner worker pod garbage collector"
@@ -76,78 +46,12 @@ func main() {
 		}
 	}
 
-	runningWorkerPods, err := rktrunner.GetWorkerPodUuids(false)
+	runtime, err := rktrunner.NewRuntimeFromConfigFile(rktrunner.DefaultConfigFile)
 	if err != nil {
-		return
-	}
-
-	err = rktrunner.VisitPods(func(pod *rktrunner.VisitedPod) bool {
-		if pod.State == "running" && strings.HasPrefix(pod.AppName, rktrunner.WORKER_APPNAME_PREFIX) {
-			runningWorkerPods[pod.UUID] = true
-			stop := false
-			podState := "idle"
-			var podlock *os.File
-			var expired bool
-			var err error
-			if pod.Started != "" {
-				started, err := time.Parse("2006-01-02 15:04:05.9 -0700 MST", pod.Started)
-				if err != nil {
-					die("failed to parse start time for pod %s: %v", pod.UUID, err)
-				}
-				expiry := started.Add(gracePeriod)
-				expired = time.Now().After(expiry)
-			}
-			if !expired {
-				fmt.Fprintf(os.Stderr, "skip baby %s\n", pod)
-			} else {
-				podlock, err = lockPod(pod.UUID)
-				if err != nil {
-					errno, isErrno := err.(syscall.Errno)
-					if isErrno && errno == syscall.EAGAIN {
-						fmt.Fprintf(os.Stderr, "skip busy %s\n", pod)
-					} else {
-						_, isPathError := err.(*os.PathError)
-						if isPathError {
-							// shouldn't happen, so clean up the mess
-							stop = true
-							podState = "orphaned"
-						} else {
-							fmt.Fprintf(os.Stderr, "warning: %s %v %T\n", pod, err, err)
-						}
-					}
-				} else if podlock != nil {
-					stop = true
-				}
-			}
-			if stop {
-				if *dryRun {
-					fmt.Fprintf(os.Stderr, "stop %s %s\n", podState, pod)
-				} else {
-					err = stopPod(pod, podState)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "warning: %s %v\n", pod, err)
-					} else {
-						os.Remove(rktrunner.WorkerPodDir(pod.UUID))
-					}
-				}
-				if podlock != nil {
-					podlock.Close()
-				}
-			}
-		}
-		return true
-	})
-
-	// clean up any worker pod directories that don't have running pods
-	for uuid, running := range runningWorkerPods {
-		if !running {
-			fmt.Fprintf(os.Stderr, "warning: spurious lockdir for pod %s, removing\n", uuid)
-			if !*dryRun {
-				os.Remove(rktrunner.WorkerPodDir(uuid))
-			}
-		}
+		die("%v", err)
 	}
 
+	err = rktrunner.CollectWorkerPods(runtime, gracePeriod, *dryRun)
 	if err != nil {
 		die("%v", err)
 	}
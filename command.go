@@ -21,15 +21,45 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
+// ExitTimeout is the exit status of a command which timed out
+const ExitTimeout = 124
+
+// TimeoutError is returned by Wait for a command which timed out
+type TimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", e.Timeout)
+}
+
+// SignalError is the result of rkt-run itself being terminated by a
+// signal, when there was no command to which to forward it
+type SignalError struct {
+	Signal syscall.Signal
+}
+
+func (e *SignalError) Error() string {
+	return fmt.Sprintf("terminated by %v", e.Signal)
+}
+
 type CommandT struct {
 	argv0      string
 	argv       []string
 	envv       []string
 	extraFiles []*os.File
 	cmd        *exec.Cmd
+	timeout    time.Duration
+	grace      time.Duration
+	timer      *time.Timer
+	timedOut   int32
+	done       chan struct{}
 }
 
 func NewCommand(argv0 string) *CommandT {
@@ -57,8 +87,9 @@ func (c *CommandT) Print(w io.Writer) {
 }
 
 func (c *CommandT) create(preserveStdio bool) {
-	c.cmd = exec.Command(c.argv[0], c.argv[1:]...)
-	c.cmd.Path = c.argv0
+	// look up argv0 rather than argv[0], which is simply the basename
+	c.cmd = exec.Command(c.argv0, c.argv[1:]...)
+	c.cmd.Args[0] = c.argv[0]
 	c.cmd.Env = c.envv
 	if preserveStdio {
 		c.cmd.Stdin = os.Stdin
@@ -72,26 +103,85 @@ func (c *CommandT) PreserveFile(f *os.File) {
 	c.extraFiles = append(c.extraFiles, f)
 }
 
+// SetTimeout arranges for the command to be sent SIGTERM if it is still
+// running after the timeout, and SIGKILL if still running after the grace
+// period following that.
+func (c *CommandT) SetTimeout(timeout, grace time.Duration) {
+	c.timeout = timeout
+	c.grace = grace
+}
+
+func (c *CommandT) start() error {
+	err := c.cmd.Start()
+	if err == nil && c.timeout > 0 {
+		c.done = make(chan struct{})
+		c.timer = time.AfterFunc(c.timeout, c.expire)
+	}
+	return err
+}
+
+// expire terminates the command on timeout
+func (c *CommandT) expire() {
+	atomic.StoreInt32(&c.timedOut, 1)
+	WarnOnFailure(c.Signal(syscall.SIGTERM))
+	select {
+	case <-c.done:
+	case <-time.After(c.grace):
+		WarnOnFailure(c.Signal(syscall.SIGKILL))
+	}
+}
+
 func (c *CommandT) Run() error {
-	c.create(true)
-	return c.cmd.Run()
+	err := c.Start()
+	if err != nil {
+		return err
+	}
+	return c.Wait()
 }
 
 func (c *CommandT) Start() error {
 	c.create(true)
-	return c.cmd.Start()
+	return c.start()
 }
 
 func (c *CommandT) StartDaemon() error {
 	c.create(false)
-	return c.cmd.Start()
+	return c.start()
+}
+
+// Output runs the command, returning its standard output
+func (c *CommandT) Output() (string, error) {
+	c.create(false)
+	c.cmd.Stderr = os.Stderr
+	out, err := c.cmd.Output()
+	return string(out), err
 }
 
 func (c *CommandT) Wait() error {
-	if c.cmd != nil {
-		return c.cmd.Wait()
+	if c.cmd == nil {
+		return nil
 	}
-	return nil
+	err := c.cmd.Wait()
+	if c.timer != nil {
+		c.timer.Stop()
+		close(c.done)
+		if atomic.LoadInt32(&c.timedOut) != 0 {
+			return &TimeoutError{Timeout: c.timeout, Err: err}
+		}
+	}
+	return err
+}
+
+// Signal sends the signal to the command, if it is running
+func (c *CommandT) Signal(sig os.Signal) error {
+	if c.cmd == nil || c.cmd.Process == nil {
+		return nil
+	}
+	err := c.cmd.Process.Signal(sig)
+	if err == os.ErrProcessDone {
+		return nil
+	}
+	return err
 }
 
 func (c *CommandT) Exec() error {
@@ -104,3 +194,27 @@ func (c *CommandT) Exec() error {
 	}
 	return syscall.Exec(c.argv0, c.argv, c.envv)
 }
+
+// ExitStatus returns the exit status of a command which failed with err,
+// as reported by the shell, i.e. 128+n for a command killed by signal n,
+// or ExitTimeout for a command which timed out.  Any error other than the
+// command exiting unsuccessfully is status 1.
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if _, isTimeout := err.(*TimeoutError); isTimeout {
+		return ExitTimeout
+	}
+	if signalErr, isSignal := err.(*SignalError); isSignal {
+		return 128 + int(signalErr.Signal)
+	}
+	exitErr, isExitErr := err.(*exec.ExitError)
+	if !isExitErr {
+		return 1
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
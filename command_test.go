@@ -0,0 +1,45 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		script   string
+		expected int
+	}{
+		{"exit 0", 0},
+		{"exit 1", 1},
+		{"exit 139", 139},
+		{"kill -TERM $$", 143},
+		{"kill -KILL $$", 137},
+	}
+	for _, test := range tests {
+		c := NewCommand("/bin/sh")
+		c.AppendArgs("-c", test.script)
+		status := ExitStatus(c.Run())
+		if status != test.expected {
+			t.Errorf("%s: exit status %d, expected %d", test.script, status, test.expected)
+		}
+	}
+
+	if status := ExitStatus(errors.New("not an exit error")); status != 1 {
+		t.Errorf("exit status %d for other error", status)
+	}
+}
@@ -18,23 +18,69 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type configT struct {
+	Include               []string
+	Runtime               string
 	Rkt                   string
+	Helper                string
+	LockFile              string            `toml:"lock-file"`
 	PreserveCwd           bool              `toml:"preserve-cwd"`
 	UsePath               bool              `toml:"use-path"`
 	WorkerPods            bool              `toml:"worker-pods"`
+	WorkerMaxSessions     int               `toml:"worker-max-sessions"`
 	RestrictImages        bool              `toml:"restrict-images"`
 	ExecSlaveDir          string            `toml:"exec-slave-dir"`
 	AutoImagePrefix       map[string]string `toml:"auto-image-prefix"`
 	DefaultInteractiveCmd string            `toml:"default-interactive-cmd"`
-	Environment           map[string]string `toml:"environment"`
+	DefaultAccess         AccessT           `toml:"default-access"`
+	Audit                 AuditT
+	SignalGracePeriod     DurationT                    `toml:"signal-grace-period"`
+	GroupLimits           map[string]ResourceMaximumsT `toml:"group-limits"`
+	Environment           map[string]string            `toml:"environment"`
 	Options               ModeOptionsT
 	Volume                map[string]VolumeT
 	Alias                 map[string]ImageAliasT
+	ResourceLimitsT
+	ResourceMaximumsT
+
+	// volume names in the order they appear in the config files
+	volumeOrder []string
+
+	// config file for each alias, volume, etc, see Source()
+	sources map[string]string
+
+	// pinned digests by image, from the lock file and aliases
+	digests map[string]string
+
+	// keys in the main config file which were not recognised
+	undecoded []toml.Key
+
+	// keys in included files which were not allowed there
+	includeUndecoded []includeKeyT
+}
+
+// includeKeyT is a key in an included config file
+type includeKeyT struct {
+	path string
+	key  toml.Key
+}
+
+// DurationT is a duration in the config file, such as "10s" or "1h30m"
+type DurationT struct {
+	time.Duration
+}
+
+func (d *DurationT) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
 }
 
 type ModeOptionsT map[string]ClassOptionsT
@@ -44,10 +90,19 @@ type VolumeT struct {
 	Volume    string
 	Mount     string
 	OnRequest bool `toml:"on-request"`
+	Order     int
+	AccessT
 }
 
 type ImageAliasT struct {
 	Image                string
+	Digest               string
+	Description          string
+	Homepage             string
+	Tags                 []string
+	Category             string
+	Versions             map[string]string
+	DefaultVersion       string `toml:"default-version"`
 	Exec                 []string
 	Environment          map[string]string
 	Passwd               []string
@@ -55,9 +110,25 @@ type ImageAliasT struct {
 	HostTimezone         bool     `toml:"host-timezone"`
 	EnvironmentUpdate    []string `toml:"environment-update"`
 	EnvironmentBlacklist []string `toml:"environment-blacklist"`
+	Timeout              DurationT
+	ResourceLimitsT
+	ResourceMaximumsT
+	AccessT
+}
+
+// VersionSeparator separates an alias from its version, e.g. samtools@1.4.1
+const VersionSeparator = '@'
+
+// defaultImage returns the image for the alias without a version
+func (a *ImageAliasT) defaultImage() string {
+	if a.DefaultVersion != "" {
+		return a.Versions[a.DefaultVersion]
+	}
+	return a.Image
 }
 
 const OptionsTable = "options"
+const VolumeTable = "volume"
 
 // valid modes
 const BatchMode = "batch"
@@ -70,26 +141,28 @@ const FetchClass = "fetch"
 const RunClass = "run"
 const ImageClass = "image"
 
-func validateOptionsForModes(modeOptions ModeOptionsT) error {
+func validateOptionsForModes(ch *configCheckerT, modeOptions ModeOptionsT) {
 	type validModeT map[string]bool
 	validMode := validModeT{
 		BatchMode:       true,
 		InteractiveMode: true,
 		CommonMode:      true,
 	}
-	for mode, classOptions := range modeOptions {
+	modes := make([]string, 0, len(modeOptions))
+	for mode := range modeOptions {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	for _, mode := range modes {
 		if !validMode[mode] {
-			return fmt.Errorf("invalid %s.%s", OptionsTable, mode)
-		}
-		err := validateClassOptions(mode, classOptions)
-		if err != nil {
-			return err
+			ch.reportf(toml.Key{OptionsTable, mode}, "invalid %s.%s", OptionsTable, mode)
+			continue
 		}
+		validateClassOptions(ch, mode, modeOptions[mode])
 	}
-	return nil
 }
 
-func validateClassOptions(mode string, classOptions ClassOptionsT) error {
+func validateClassOptions(ch *configCheckerT, mode string, classOptions ClassOptionsT) {
 	type validClassT map[string]bool
 	validClass := validClassT{
 		GeneralClass: true,
@@ -100,14 +173,57 @@ func validateClassOptions(mode string, classOptions ClassOptionsT) error {
 
 	for class := range classOptions {
 		if !validClass[class] {
-			return fmt.Errorf("invalid %s.%s.%s", OptionsTable, mode, class)
+			ch.reportf(toml.Key{OptionsTable, mode, class}, "invalid %s.%s.%s", OptionsTable, mode, class)
 		}
 	}
-	return nil
 }
 
-func GetConfig(path string, c *configT) error {
-	_, err := toml.DecodeFile(path, c)
+// tableOrder returns the names of the subtables of table,
+// in the order they were defined
+func tableOrder(md toml.MetaData, table string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, key := range md.Keys() {
+		if len(key) > 1 && key[0] == table && !seen[key[1]] {
+			seen[key[1]] = true
+			names = append(names, key[1])
+		}
+	}
+	return names
+}
+
+// volumeNames returns the names of all volumes, ordered by their order
+// key, then as they appear in the config file, then by name.
+func (c *configT) volumeNames() []string {
+	position := make(map[string]int)
+	for i, name := range c.volumeOrder {
+		position[name] = i
+	}
+	names := make([]string, 0, len(c.Volume))
+	for name := range c.Volume {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := names[i], names[j]
+		if c.Volume[a].Order != c.Volume[b].Order {
+			return c.Volume[a].Order < c.Volume[b].Order
+		}
+		posA, knownA := position[a]
+		posB, knownB := position[b]
+		if knownA != knownB {
+			return knownA
+		}
+		if posA != posB {
+			return posA < posB
+		}
+		return a < b
+	})
+	return names
+}
+
+// readConfig reads the config file and any included files, without validation.
+func readConfig(path string, c *configT) error {
+	md, err := toml.DecodeFile(path, c)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			// provide some context
@@ -115,37 +231,126 @@ func GetConfig(path string, c *configT) error {
 		}
 		return err
 	}
+	c.volumeOrder = tableOrder(md, VolumeTable)
+	c.undecoded = md.Undecoded()
 
-	// validate
-	if c.Rkt == "" {
-		return fmt.Errorf("missing rkt")
+	err = c.mergeIncludes(path)
+	if err != nil {
+		return err
+	}
+	return c.readDigests()
+}
+
+// validate checks the rules which must be satisfied for the config to be usable.
+func (c *configT) validate(ch *configCheckerT) {
+	for _, inc := range c.includeUndecoded {
+		ch.reportAtf(inc.path, inc.key, "%s not allowed in included file", inc.key)
 	}
 
 	if c.PreserveCwd && c.ExecSlaveDir == "" {
-		return fmt.Errorf("preserve-stdio requires exec-slave-dir")
+		ch.reportf(toml.Key{"preserve-cwd"}, "preserve-cwd requires exec-slave-dir")
 	}
 	if c.UsePath && c.ExecSlaveDir == "" {
-		return fmt.Errorf("use-path requires exec-slave-dir")
+		ch.reportf(toml.Key{"use-path"}, "use-path requires exec-slave-dir")
 	}
 	if c.ExecSlaveDir != "" {
 		p := filepath.Join(c.ExecSlaveDir, slaveRunner)
 		_, err := os.Stat(p)
 		if err != nil {
-			return err
+			ch.reportf(toml.Key{"exec-slave-dir"}, "%v", err)
+		}
+	}
+
+	if c.SignalGracePeriod.Duration < 0 {
+		ch.reportf(toml.Key{"signal-grace-period"}, "signal-grace-period cannot be negative")
+	}
+
+	if c.WorkerMaxSessions < 0 {
+		ch.reportf(toml.Key{"worker-max-sessions"}, "worker-max-sessions cannot be negative")
+	} else if c.WorkerMaxSessions > 0 && !c.WorkerPods {
+		ch.reportf(toml.Key{"worker-max-sessions"}, "worker-max-sessions requires worker-pods")
+	}
+
+	c.ResourceLimitsT.validate(ch, nil, c.ExecSlaveDir != "")
+	c.ResourceMaximumsT.validate(ch, nil, &c.ResourceLimitsT)
+
+	if c.LockFile != "" && !filepath.IsAbs(c.LockFile) {
+		ch.reportf(toml.Key{"lock-file"}, "lock-file must be an absolute path")
+	}
+
+	if c.Audit.File != "" && !filepath.IsAbs(c.Audit.File) {
+		ch.reportf(toml.Key{"audit", "file"}, "audit file must be an absolute path")
+	}
+
+	for _, volumeKey := range sortedKeys(c.Volume) {
+		volumeVal := c.Volume[volumeKey]
+		if !volumeVal.OnRequest && !volumeVal.isEmpty() {
+			ch.reportf(toml.Key{VolumeTable, volumeKey}, "volume access control requires on-request")
 		}
 	}
 
-	for _, aliasVal := range c.Alias {
-		if (aliasVal.Passwd != nil || aliasVal.Group != nil) && !c.WorkerPods {
-			return fmt.Errorf("passwd/group requires worker-pods")
+	for _, aliasKey := range sortedKeys(c.Alias) {
+		aliasVal := c.Alias[aliasKey]
+		if aliasVal.Passwd != nil && !c.WorkerPods {
+			ch.reportf(toml.Key{AliasTable, aliasKey, "passwd"}, "passwd/group requires worker-pods")
+		}
+		if aliasVal.Group != nil && !c.WorkerPods {
+			ch.reportf(toml.Key{AliasTable, aliasKey, "group"}, "passwd/group requires worker-pods")
 		}
 		if aliasVal.HostTimezone && !c.WorkerPods {
-			return fmt.Errorf("host-timezone requires worker-pods")
+			ch.reportf(toml.Key{AliasTable, aliasKey, "host-timezone"}, "host-timezone requires worker-pods")
+		}
+		if aliasVal.Image != "" {
+			if err := validateImage(aliasVal.Image); err != nil {
+				ch.reportf(toml.Key{AliasTable, aliasKey, "image"}, "alias %s has %v", aliasKey, err)
+			}
+		}
+		if aliasVal.Digest != "" && !validDigest(aliasVal.Digest) {
+			ch.reportf(toml.Key{AliasTable, aliasKey, "digest"}, "alias %s has invalid digest %s, expected %s...", aliasKey, aliasVal.Digest, digestPrefix)
+		}
+		if aliasVal.Digest != "" && len(aliasVal.Versions) > 0 {
+			ch.reportf(toml.Key{AliasTable, aliasKey, "digest"}, "alias %s cannot have both digest and versions, which may be pinned by the lock file", aliasKey)
+		}
+		if aliasVal.DefaultVersion != "" {
+			if aliasVal.Image != "" {
+				ch.reportf(toml.Key{AliasTable, aliasKey, "default-version"}, "alias %s cannot have both image and default-version", aliasKey)
+			}
+			if _, ok := aliasVal.Versions[aliasVal.DefaultVersion]; !ok {
+				ch.reportf(toml.Key{AliasTable, aliasKey, "default-version"}, "alias %s has no version %s", aliasKey, aliasVal.DefaultVersion)
+			}
+		} else if aliasVal.Image == "" && len(aliasVal.Versions) > 0 {
+			ch.reportf(toml.Key{AliasTable, aliasKey}, "alias %s requires image or default-version", aliasKey)
+		}
+		for _, version := range sortedKeys(aliasVal.Versions) {
+			if version == "" || strings.ContainsRune(version, VersionSeparator) {
+				ch.reportf(toml.Key{AliasTable, aliasKey, "versions", version}, "alias %s has invalid version %q", aliasKey, version)
+			}
+			if err := validateImage(aliasVal.Versions[version]); err != nil {
+				ch.reportf(toml.Key{AliasTable, aliasKey, "versions", version}, "alias %s has %v", aliasKey, err)
+			}
+		}
+		aliasVal.ResourceLimitsT.validate(ch, toml.Key{AliasTable, aliasKey}, c.ExecSlaveDir != "")
+		aliasLimits := c.ResourceLimitsT.override(&aliasVal.ResourceLimitsT)
+		aliasMaximums := c.ResourceMaximumsT.override(&aliasVal.ResourceMaximumsT)
+		aliasMaximums.validate(ch, toml.Key{AliasTable, aliasKey}, &aliasLimits)
+		if aliasVal.Timeout.Duration < 0 {
+			ch.reportf(toml.Key{AliasTable, aliasKey, "timeout"}, "timeout cannot be negative")
 		}
 		if aliasVal.EnvironmentUpdate != nil && c.ExecSlaveDir == "" {
-			return fmt.Errorf("environment-update requires exec-slave-dir")
+			ch.reportf(toml.Key{AliasTable, aliasKey, "environment-update"}, "environment-update requires exec-slave-dir")
 		}
 	}
 
-	return validateOptionsForModes(c.Options)
+	validateOptionsForModes(ch, c.Options)
+}
+
+func GetConfig(path string, c *configT) error {
+	err := readConfig(path, c)
+	if err != nil {
+		return err
+	}
+
+	ch := newConfigChecker(path, c)
+	c.validate(ch)
+	return ch.firstProblem()
 }
@@ -0,0 +1,164 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfigFiles writes the named files into a new directory, returning its path.
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const includingConfig = `
+rkt = "/usr/bin/rkt"
+include = ["rktrunner.d/*.toml"]
+
+[environment]
+HOME = "/home/{{.Username}}"
+
+[volume.home]
+volume = "kind=host,source={{.HomeDir}}"
+mount = "target=/home/{{.Username}}"
+
+[alias.blast_]
+image = "quay.io/biocontainers/blast:2.6.0--boost1.61_0"
+exec = ["blastn"]
+`
+
+func TestGetConfigInclude(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"rktrunner.toml": includingConfig,
+		"rktrunner.d/samtools.toml": `
+[alias.samtools_]
+image = "quay.io/biocontainers/samtools:1.4.1--0"
+exec = ["samtools"]
+
+[volume.dataset]
+volume = "kind=host,source=/dataset"
+on-request = true
+`,
+		"rktrunner.d/proxy.toml": `
+[environment]
+http_proxy = "{{.http_proxy}}"
+
+[auto-image-prefix]
+"biocontainers/" = "docker://biocontainers/"
+
+[volume.bifo]
+volume = "kind=host,source=/bifo"
+`,
+		"rktrunner.d/README": "not included",
+	})
+
+	var c configT
+	err := GetConfig(filepath.Join(dir, "rktrunner.toml"), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Alias["samtools_"].Image != "quay.io/biocontainers/samtools:1.4.1--0" || c.Alias["blast_"].Image == "" {
+		t.Errorf("aliases not merged: %v", c.Alias)
+	}
+	if c.Environment["http_proxy"] == "" || c.Environment["HOME"] == "" {
+		t.Errorf("environment not merged: %v", c.Environment)
+	}
+	if c.AutoImagePrefix["biocontainers/"] != "docker://biocontainers/" {
+		t.Errorf("auto-image-prefix not merged: %v", c.AutoImagePrefix)
+	}
+	if !c.Volume["dataset"].OnRequest {
+		t.Errorf("volume not merged: %v", c.Volume)
+	}
+	// included files are merged in lexical order
+	expectedVolumes := []string{"home", "bifo", "dataset"}
+	if volumes := c.volumeNames(); !reflect.DeepEqual(volumes, expectedVolumes) {
+		t.Errorf("volumes %v, expected %v", volumes, expectedVolumes)
+	}
+	if source := c.Source(AliasTable, "samtools_"); source != filepath.Join(dir, "rktrunner.d/samtools.toml") {
+		t.Errorf("samtools_ source %s", source)
+	}
+	if source := c.Source(AliasTable, "blast_"); source != filepath.Join(dir, "rktrunner.toml") {
+		t.Errorf("blast_ source %s", source)
+	}
+}
+
+func TestGetConfigIncludeConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{
+			"alias",
+			map[string]string{
+				"rktrunner.d/a.toml": "[alias.blast_]\nimage = \"blast\"\n",
+			},
+			"alias blast_ defined in both $DIR/rktrunner.toml and $DIR/rktrunner.d/a.toml",
+		},
+		{
+			"volume",
+			map[string]string{
+				"rktrunner.d/a.toml": "[volume.dataset]\nvolume = \"kind=empty\"\n",
+				"rktrunner.d/b.toml": "[volume.dataset]\nvolume = \"kind=empty\"\n",
+			},
+			"volume dataset defined in both $DIR/rktrunner.d/a.toml and $DIR/rktrunner.d/b.toml",
+		},
+		{
+			"environment",
+			map[string]string{
+				"rktrunner.d/a.toml": "[environment]\nHOME = \"/tmp\"\n",
+			},
+			"environment HOME defined in both $DIR/rktrunner.toml and $DIR/rktrunner.d/a.toml",
+		},
+		{
+			"disallowed",
+			map[string]string{
+				"rktrunner.d/a.toml": "worker-pods = true\n",
+			},
+			"$DIR/rktrunner.d/a.toml:1: worker-pods not allowed in included file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.files["rktrunner.toml"] = includingConfig
+			dir := writeConfigFiles(t, test.files)
+
+			var c configT
+			err := GetConfig(filepath.Join(dir, "rktrunner.toml"), &c)
+			expected := strings.Replace(test.expected, "$DIR", dir, -1)
+			if err == nil || err.Error() != expected {
+				t.Errorf("GetConfig error %v, expected %s", err, expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+const digestPrefix = "sha512-"
+
+// digests shorter than this are too ambiguous to be worth pinning
+const minDigestLength = len(digestPrefix) + 12
+
+// lockFileT is the lock file written by rkt-run --pin,
+// mapping each image to its digest
+type lockFileT struct {
+	Digest map[string]string
+}
+
+// validDigest returns whether the digest is an image hash, such as
+// sha512-0123456789ab, possibly abbreviated as by rkt image list.
+func validDigest(digest string) bool {
+	if !strings.HasPrefix(digest, digestPrefix) || len(digest) < minDigestLength {
+		return false
+	}
+	for _, c := range digest[len(digestPrefix):] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// digestMatches returns whether the image ID matches the pinned digest
+func digestMatches(id, digest string) bool {
+	return strings.HasPrefix(id, digest)
+}
+
+// readLockFile reads the digests from the lock file, which need not exist
+func readLockFile(path string) (map[string]string, error) {
+	var lock lockFileT
+	_, err := toml.DecodeFile(path, &lock)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s %v", path, err)
+	}
+	return lock.Digest, nil
+}
+
+// writeLockFile replaces the lock file with the digests
+func writeLockFile(path string, digests map[string]string) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# written by rkt-run --pin\n\n[digest]\n")
+	for _, image := range sortedKeys(digests) {
+		fmt.Fprintf(&b, "%s = %s\n", strconv.Quote(image), strconv.Quote(digests[image]))
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b.Bytes())
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// readDigests reads the lock file, if any, and overlays the digests
+// given explicitly for aliases.
+func (c *configT) readDigests() error {
+	var err error
+	if c.LockFile != "" {
+		c.digests, err = readLockFile(c.LockFile)
+		if err != nil {
+			return err
+		}
+	}
+	for _, aliasKey := range sortedKeys(c.Alias) {
+		aliasVal := c.Alias[aliasKey]
+		if aliasVal.Digest != "" {
+			if c.digests == nil {
+				c.digests = make(map[string]string)
+			}
+			c.digests[aliasVal.defaultImage()] = aliasVal.Digest
+		}
+	}
+	return nil
+}
+
+// pinnedDigest returns the digest to which the image is pinned, if any
+func (c *configT) pinnedDigest(image string) string {
+	return c.digests[image]
+}
+
+// imageIDs returns the IDs of the images which have been fetched, by
+// name, of which there may be several if the image was re-fetched.
+func (r *RunnerT) imageIDs() (map[string][]string, error) {
+	images, err := r.runtime.Images()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+	ids := make(map[string][]string)
+	for _, image := range images {
+		ids[image.Name] = append(ids[image.Name], image.ID)
+	}
+	return ids, nil
+}
+
+// fetchImage fetches the image, returning its ID
+func (r *RunnerT) fetchImage(image string) (string, error) {
+	c := r.runtime.FetchCommand(&FetchSpec{
+		GeneralOptions: r.fragments.Options[r.mode][GeneralClass],
+		FetchOptions:   r.fragments.Options[r.mode][FetchClass],
+		Image:          image,
+	})
+	c.SetEnviron(os.Environ())
+	if r.request.Verbose {
+		c.Print(os.Stderr)
+	}
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %v", image, err)
+	}
+	// the image ID is the last line of output
+	fields := strings.Fields(out)
+	if len(fields) == 0 || !validDigest(fields[len(fields)-1]) {
+		return "", fmt.Errorf("failed to fetch %s: no image ID", image)
+	}
+	return fields[len(fields)-1], nil
+}
+
+// verifyDigest checks that the image matches its pinned digest, if any,
+// fetching it first if necessary, and sets the image ID to be run.
+func (r *RunnerT) verifyDigest() error {
+	digest := r.config.pinnedDigest(r.image)
+	if digest == "" {
+		return nil
+	}
+	all, err := r.imageIDs()
+	if err != nil {
+		return err
+	}
+	ids := all[CanonicalImageName(r.image)]
+	if len(ids) == 0 {
+		id, err := r.fetchImage(r.image)
+		if err != nil {
+			return err
+		}
+		ids = []string{id}
+	}
+	var matching []string
+	for _, id := range ids {
+		if digestMatches(id, digest) {
+			matching = append(matching, id)
+		}
+	}
+	switch len(matching) {
+	case 0:
+		return fmt.Errorf("image %s has digest %s, not pinned digest %s", r.image, strings.Join(ids, ", "), digest)
+	case 1:
+		r.imageID = matching[0]
+		return nil
+	default:
+		return fmt.Errorf("image %s is ambiguous, pinned digest %s matches %s", r.image, digest, strings.Join(matching, ", "))
+	}
+}
+
+// pin fetches the images for all aliases, and writes their digests
+// to the lock file, reporting each to w.
+func (r *RunnerT) pin(w io.Writer) error {
+	if r.config.LockFile == "" {
+		return fmt.Errorf("pin requires lock-file")
+	}
+
+	seen := make(map[string]bool)
+	var images []string
+	for _, alias := range r.aliases {
+		if !seen[alias.image] {
+			seen[alias.image] = true
+			images = append(images, alias.image)
+		}
+	}
+	sort.Strings(images)
+
+	digests := make(map[string]string)
+	for _, image := range images {
+		id, err := r.fetchImage(image)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s %s\n", image, id)
+		digests[image] = id
+	}
+	return writeLockFile(r.config.LockFile, digests)
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha512-0123456789abcdef"
+
+func TestValidDigest(t *testing.T) {
+	for _, c := range []struct {
+		digest string
+		valid  bool
+	}{
+		{"sha512-0123456789ab", true},
+		{testDigest, true},
+		{"sha512-0123456789a", false},
+		{"sha256-0123456789abcdef", false},
+		{"sha512-0123456789ABCDEF", false},
+		{"", false},
+	} {
+		if validDigest(c.digest) != c.valid {
+			t.Errorf("validDigest(%s) = %t, expected %t", c.digest, !c.valid, c.valid)
+		}
+	}
+}
+
+func TestRunPinnedDigest(t *testing.T) {
+	tests := []struct {
+		name     string
+		images   string
+		fetch    string
+		status   int
+		expected string
+		sub      []string
+		run      string
+	}{
+		{
+			name:   "match",
+			images: testDigest + "0000\texample.com/tools/busybox:1.0\n",
+			sub:    []string{"image", "run"},
+			run:    testDigest + "0000",
+		},
+		{
+			name:     "mismatch",
+			images:   "sha512-fedcba9876543210\texample.com/tools/busybox:1.0\n",
+			status:   1,
+			expected: "not pinned digest " + testDigest,
+			sub:      []string{"image"},
+		},
+		{
+			name:  "fetch",
+			fetch: testDigest + "0000\n",
+			sub:   []string{"image", "fetch", "run"},
+			run:   testDigest + "0000",
+		},
+		{
+			name:     "fetch mismatch",
+			fetch:    "sha512-fedcba9876543210\n",
+			status:   1,
+			expected: "not pinned digest " + testDigest,
+			sub:      []string{"image", "fetch"},
+		},
+		{
+			name:   "refetched",
+			images: testDigest + "0000\texample.com/tools/busybox:1.0\nsha512-fedcba9876543210\texample.com/tools/busybox:1.0\n",
+			sub:    []string{"image", "run"},
+			run:    testDigest + "0000",
+		},
+		{
+			name:     "ambiguous",
+			images:   testDigest + "0000\texample.com/tools/busybox:1.0\n" + testDigest + "1111\texample.com/tools/busybox:1.0\n",
+			status:   1,
+			expected: "image example.com/tools/busybox:1.0 is ambiguous",
+			sub:      []string{"image"},
+		},
+	}
+	for _, test := range tests {
+		h := newHarness(t)
+		h.writeConfig(testConfig + "digest = \"" + testDigest + "\"\n")
+		h.script("image", test.images, 0)
+		h.script("fetch", test.fetch, 0)
+
+		stderr, status := h.rktRun("grep", "needle")
+		if status != test.status || !strings.Contains(stderr, test.expected) {
+			t.Errorf("%s: exit status %d: %s", test.name, status, stderr)
+		}
+		var sub []string
+		for _, argv := range h.invocations() {
+			for _, arg := range argv[1:] {
+				if !strings.HasPrefix(arg, "-") {
+					sub = append(sub, arg)
+					if arg == "run" && !containsAny(argv, test.run) {
+						t.Errorf("%s: rkt run %v, expected image %s", test.name, argv, test.run)
+					}
+					break
+				}
+			}
+		}
+		if strings.Join(sub, " ") != strings.Join(test.sub, " ") {
+			t.Errorf("%s: rkt subcommands %v, expected %v", test.name, sub, test.sub)
+		}
+	}
+}
+
+func TestGetConfigDigestVersions(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"rktrunner.toml": testConfig + "digest = \"" + testDigest + "\"\n[alias.busybox_.versions]\n\"1.1\" = \"example.com/tools/busybox:1.1\"\n",
+	})
+
+	var c configT
+	err := GetConfig(filepath.Join(dir, "rktrunner.toml"), &c)
+	if err == nil || !strings.Contains(err.Error(), "alias busybox_ cannot have both digest and versions") {
+		t.Errorf("GetConfig error %v, expected digest and versions", err)
+	}
+}
+
+func TestPin(t *testing.T) {
+	h := newHarness(t)
+	lockFile := filepath.Join(h.workDir, "rktrunner.lock")
+	h.writeConfig("lock-file = \"" + lockFile + "\"\n" + testConfig + `
+[alias.samtools]
+image = "docker://biocontainers/samtools:1.3.1"
+`)
+	h.script("fetch", "Downloading layer\n"+testDigest+"\n", 0)
+
+	cmd, stderr := h.rktRunCommand("--pin")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("rkt-run --pin: %v\n%s", err, stderr.String())
+	}
+	expected := "docker://biocontainers/samtools:1.3.1 " + testDigest + "\n" +
+		"example.com/tools/busybox:1.0 " + testDigest + "\n"
+	if stdout.String() != expected {
+		t.Errorf("rkt-run --pin printed:\n%s\nexpected:\n%s", stdout.String(), expected)
+	}
+
+	digests, err := readLockFile(lockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 2 || digests["example.com/tools/busybox:1.0"] != testDigest {
+		b, _ := ioutil.ReadFile(lockFile)
+		t.Errorf("unexpected lock file:\n%s", b)
+	}
+
+	// the lock file now pins the images
+	h.script("image", "sha512-fedcba9876543210\texample.com/tools/busybox:1.0\n", 0)
+	stderr2, status := h.rktRun("grep", "needle")
+	if status == 0 || !strings.Contains(stderr2, "not pinned digest") {
+		t.Errorf("expected pinned digest mismatch, got status %d: %s", status, stderr2)
+	}
+}
@@ -15,6 +15,8 @@
 package rktrunner
 
 import (
+	"fmt"
+	"regexp"
 	"strings"
 )
 
@@ -37,27 +39,169 @@ var distributions []distributionT = []distributionT{
 	},
 }
 
-// CanonicalImageName converts the convenience prefixes into official
-// paths, and ensures there is a tag suffix, by appending :latest if required.
-func CanonicalImageName(raw string) string {
-	canonical := raw
+const defaultTag = "latest"
+
+var (
+	registryRegexp      = regexp.MustCompile(`^(localhost|[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)*)(:[0-9]+)?$`)
+	pathComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(([._]|__|-+)[a-z0-9]+)*$`)
+	tagRegexp           = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127}$`)
+	digestRegexp        = regexp.MustCompile(`^[a-z0-9]+([+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$`)
+)
+
+// ImageReference is an image name, parsed into its parts.  Registry is
+// empty for an image without a distribution prefix and registry host,
+// such as an ACI name.
+type ImageReference struct {
+	Prefix     string
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// isRegistry returns whether the first component of an image path is a
+// registry host, rather than part of the repository path.
+func isRegistry(component string) bool {
+	return component == "localhost" || strings.ContainsAny(component, ".:")
+}
+
+// ParseImageReference parses an image name, with optional distribution
+// prefix, registry host and port, tag, and digest, for example
+// docker://quay.io:443/biocontainers/samtools:1.3.1@sha256:0123...
+// The convenience prefixes are resolved into their default registry
+// and repository prefix.
+func ParseImageReference(raw string) (*ImageReference, error) {
+	ref := &ImageReference{}
+	name := raw
+	var d *distributionT
+	for i := range distributions {
+		if strings.HasPrefix(raw, distributions[i].prefix) {
+			d = &distributions[i]
+			ref.Prefix = d.prefix
+			name = raw[len(d.prefix):]
+			break
+		}
+	}
+
+	if at := strings.IndexByte(name, '@'); at >= 0 {
+		ref.Digest = name[at+1:]
+		name = name[:at]
+		if !digestRegexp.MatchString(ref.Digest) {
+			return nil, fmt.Errorf("invalid digest %s in image %s", ref.Digest, raw)
+		}
+	}
+
+	// a tag is after the last colon, unless that is a port in the registry
+	if colon := strings.LastIndexByte(name, ':'); colon > strings.LastIndexByte(name, '/') {
+		ref.Tag = name[colon+1:]
+		name = name[:colon]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("invalid tag %s in image %s", ref.Tag, raw)
+		}
+	}
+
+	components := strings.Split(name, "/")
+	if len(components) > 1 && isRegistry(components[0]) {
+		ref.Registry = components[0]
+		components = components[1:]
+		if !registryRegexp.MatchString(ref.Registry) {
+			return nil, fmt.Errorf("invalid registry %s in image %s", ref.Registry, raw)
+		}
+	} else if d != nil {
+		ref.Registry = strings.TrimSuffix(d.defaultIndexURL, "/")
+		if len(components) == 1 {
+			components = append(strings.Split(strings.TrimSuffix(d.defaultRepoPrefix, "/"), "/"), components...)
+		}
+	}
+
+	for _, component := range components {
+		if !pathComponentRegexp.MatchString(component) {
+			return nil, fmt.Errorf("invalid repository %s in image %s", name, raw)
+		}
+	}
+	ref.Repository = strings.Join(components, "/")
+
+	return ref, nil
+}
+
+// isDockerReference returns whether the image has a docker distribution
+// prefix, rather than being an ACI name, path or URL, or an image ID.
+func isDockerReference(raw string) bool {
 	for _, d := range distributions {
 		if strings.HasPrefix(raw, d.prefix) {
-			n := len(d.prefix)
-			var repoPrefix string
-			if strings.IndexRune(raw[n:], '/') < 0 {
-				repoPrefix = d.defaultRepoPrefix
-			}
-			canonical = d.defaultIndexURL + repoPrefix + raw[n:]
-			break
+			return true
 		}
 	}
+	return false
+}
 
-	// ensure we have a tag
-	colon := strings.IndexRune(canonical, ':')
-	if colon < 0 {
-		canonical = canonical + ":latest"
+// validateImage checks a docker image reference, and passes anything
+// else through, for rkt to resolve as it always has.
+func validateImage(raw string) error {
+	if !isDockerReference(raw) {
+		return nil
 	}
+	_, err := ParseImageReference(raw)
+	return err
+}
 
-	return canonical
+// isImageID returns whether the image is given by its ID, such as
+// sha512-0123456789ab, rather than by name.
+func isImageID(raw string) bool {
+	return strings.HasPrefix(raw, digestPrefix)
+}
+
+// repositoryName returns the registry and repository of the image,
+// without tag or digest, so identifying all versions of the image.
+// An image which cannot be parsed, such as an ACI path or URL, has
+// any tag or digest after its last path component removed.
+func repositoryName(raw string) string {
+	ref, err := ParseImageReference(raw)
+	if err != nil {
+		name := raw
+		slash := strings.LastIndexByte(name, '/')
+		if at := strings.IndexByte(name[slash+1:], '@'); at >= 0 {
+			name = name[:slash+1+at]
+		}
+		if colon := strings.LastIndexByte(name, ':'); colon > slash {
+			name = name[:colon]
+		}
+		return name
+	}
+	if ref.Registry == "" {
+		return ref.Repository
+	}
+	return ref.Registry + "/" + ref.Repository
+}
+
+// Name returns the official path of the image, without distribution
+// prefix, as listed by rkt, with a tag of latest if neither tag nor
+// digest was given.
+func (ref *ImageReference) Name() string {
+	name := ref.Repository
+	if ref.Registry != "" {
+		name = ref.Registry + "/" + name
+	}
+	tag := ref.Tag
+	if tag == "" && ref.Digest == "" {
+		tag = defaultTag
+	}
+	if tag != "" {
+		name += ":" + tag
+	}
+	if ref.Digest != "" {
+		name += "@" + ref.Digest
+	}
+	return name
+}
+
+// CanonicalImageName converts the convenience prefixes into official
+// paths, and ensures there is a tag suffix, by appending :latest if required.
+// An image name which cannot be parsed is returned unchanged.
+func CanonicalImageName(raw string) string {
+	ref, err := ParseImageReference(raw)
+	if err != nil {
+		return raw
+	}
+	return ref.Name()
 }
@@ -0,0 +1,181 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSha256 = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		raw       string
+		expected  ImageReference
+		canonical string
+	}{
+		{
+			"example.com/tools/busybox:1.0",
+			ImageReference{Registry: "example.com", Repository: "tools/busybox", Tag: "1.0"},
+			"example.com/tools/busybox:1.0",
+		},
+		{
+			"busybox",
+			ImageReference{Repository: "busybox"},
+			"busybox:latest",
+		},
+		{
+			"docker://alpine",
+			ImageReference{Prefix: "docker://", Registry: "registry-1.docker.io", Repository: "library/alpine"},
+			"registry-1.docker.io/library/alpine:latest",
+		},
+		{
+			"docker:alpine:3.6",
+			ImageReference{Prefix: "docker:", Registry: "registry-1.docker.io", Repository: "library/alpine", Tag: "3.6"},
+			"registry-1.docker.io/library/alpine:3.6",
+		},
+		{
+			"docker://biocontainers/samtools:1.3.1",
+			ImageReference{Prefix: "docker://", Registry: "registry-1.docker.io", Repository: "biocontainers/samtools", Tag: "1.3.1"},
+			"registry-1.docker.io/biocontainers/samtools:1.3.1",
+		},
+		{
+			"docker://quay.io/biocontainers/samtools",
+			ImageReference{Prefix: "docker://", Registry: "quay.io", Repository: "biocontainers/samtools"},
+			"quay.io/biocontainers/samtools:latest",
+		},
+		{
+			"docker://localhost/tools",
+			ImageReference{Prefix: "docker://", Registry: "localhost", Repository: "tools"},
+			"localhost/tools:latest",
+		},
+		{
+			"docker://myregistry:5000/foo",
+			ImageReference{Prefix: "docker://", Registry: "myregistry:5000", Repository: "foo"},
+			"myregistry:5000/foo:latest",
+		},
+		{
+			"myregistry:5000/foo/bar:2.1-rc1",
+			ImageReference{Registry: "myregistry:5000", Repository: "foo/bar", Tag: "2.1-rc1"},
+			"myregistry:5000/foo/bar:2.1-rc1",
+		},
+		{
+			"docker://ubuntu@" + testSha256,
+			ImageReference{Prefix: "docker://", Registry: "registry-1.docker.io", Repository: "library/ubuntu", Digest: testSha256},
+			"registry-1.docker.io/library/ubuntu@" + testSha256,
+		},
+		{
+			"docker://quay.io:443/biocontainers/samtools:1.3.1@" + testSha256,
+			ImageReference{Prefix: "docker://", Registry: "quay.io:443", Repository: "biocontainers/samtools", Tag: "1.3.1", Digest: testSha256},
+			"quay.io:443/biocontainers/samtools:1.3.1@" + testSha256,
+		},
+	}
+	for _, test := range tests {
+		ref, err := ParseImageReference(test.raw)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(*ref, test.expected) {
+			t.Errorf("%s: parsed as %+v, expected %+v", test.raw, *ref, test.expected)
+		}
+		if ref.Name() != test.canonical {
+			t.Errorf("%s: name %s, expected %s", test.raw, ref.Name(), test.canonical)
+		}
+		if CanonicalImageName(test.raw) != test.canonical {
+			t.Errorf("%s: canonical name %s, expected %s", test.raw, CanonicalImageName(test.raw), test.canonical)
+		}
+	}
+}
+
+func TestParseImageReferenceInvalid(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected string
+	}{
+		{"docker://", "invalid repository"},
+		{"docker://Ubuntu", "invalid repository"},
+		{"docker://foo//bar", "invalid repository"},
+		{"docker://ubuntu:", "invalid tag"},
+		{"docker://ubuntu:bad/tag", "invalid registry"},
+		{"docker://ubuntu:-rc", "invalid tag"},
+		{"docker://ubuntu@sha256", "invalid digest"},
+		{"docker://ubuntu@", "invalid digest"},
+		{"docker://my_registry.io:5000/foo", "invalid registry"},
+		{"docker://myregistry:port/foo", "invalid registry"},
+	}
+	for _, test := range tests {
+		_, err := ParseImageReference(test.raw)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expected %s, got %v", test.raw, test.expected, err)
+		}
+		if CanonicalImageName(test.raw) != test.raw {
+			t.Errorf("%s: canonical name %s, expected unchanged", test.raw, CanonicalImageName(test.raw))
+		}
+	}
+}
+
+func TestRepositoryName(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"example.com/tools/busybox:1.0":      "example.com/tools/busybox",
+		"docker://example.com/tools/busybox": "example.com/tools/busybox",
+		"docker://ubuntu@" + testSha256:      "registry-1.docker.io/library/ubuntu",
+		"example.com/Tools/x:2.0":            "example.com/Tools/x",
+		"example.com/Tools/x@sha512:0123":    "example.com/Tools/x",
+		"https://example.com:8080/foo.aci":   "https://example.com:8080/foo.aci",
+		"./foo.aci":                          "./foo.aci",
+	} {
+		if actual := repositoryName(raw); actual != expected {
+			t.Errorf("%s: repository %s, expected %s", raw, actual, expected)
+		}
+	}
+}
+
+func TestValidateImage(t *testing.T) {
+	for _, raw := range []string{
+		"./foo.aci",
+		"/abs/foo.aci",
+		"https://example.com/foo.aci",
+		"example.com/Tools/x",
+		"example.com/tools/busybox:1.0",
+	} {
+		if err := validateImage(raw); err != nil {
+			t.Errorf("%s: unexpected error %v", raw, err)
+		}
+	}
+	for _, raw := range []string{"docker://Ubuntu", "docker:ubuntu:-rc"} {
+		if err := validateImage(raw); err == nil {
+			t.Errorf("%s: expected error", raw)
+		}
+	}
+}
+
+func TestRunInvalidImage(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig)
+
+	stderr, status := h.rktRun("--dry-run", "docker://ubuntu:-rc")
+	if status == 0 || !strings.Contains(stderr, "invalid tag -rc in image docker://ubuntu:-rc") {
+		t.Errorf("expected invalid tag, got status %d: %s", status, stderr)
+	}
+
+	// ACI files are passed through to rkt
+	stderr, status = h.rktRun("--dry-run", "./foo.aci")
+	if status != 0 {
+		t.Errorf("exit status %d: %s", status, stderr)
+	}
+}
@@ -17,7 +17,6 @@ package rktrunner
 import (
 	"fmt"
 	"io"
-	"os"
 	"sort"
 	"strings"
 )
@@ -25,7 +24,7 @@ import (
 // ParseEnviron extracts all environment variables into a map
 func ParseEnviron(env []string) map[string]string {
 	environ := make(map[string]string)
-	for _, keyval := range os.Environ() {
+	for _, keyval := range env {
 		i := strings.IndexRune(keyval, '=')
 		if i != -1 {
 			key := keyval[:i]
@@ -46,23 +45,27 @@ func UpdateEnviron(environ map[string]string, keyval string) {
 	}
 }
 
-// BuildEnviron turns the environ map into a list of strings
+// environKeys returns the keys of the environ map in order
+func environKeys(environ map[string]string) []string {
+	keys := make([]string, 0, len(environ))
+	for key := range environ {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// BuildEnviron turns the environ map into a list of strings, ordered by name
 func BuildEnviron(environ map[string]string) []string {
 	var env []string
-	for key, val := range environ {
-		env = append(env, fmt.Sprintf("%s=%s", key, val))
+	for _, key := range environKeys(environ) {
+		env = append(env, fmt.Sprintf("%s=%s", key, environ[key]))
 	}
 	return env
 }
 
 func PrintEnviron(w io.Writer, environ map[string]string) {
-	// get keys in order
-	keys := make([]string, 0, len(environ))
-	for key := range environ {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
-	for _, key := range keys {
+	for _, key := range environKeys(environ) {
 		fmt.Fprintf(w, "%s=%s\n", key, environ[key])
 	}
 }
@@ -0,0 +1,36 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildEnviron(t *testing.T) {
+	environ := map[string]string{
+		"PATH":    "/usr/bin:/bin",
+		"HOME":    "/home/alice",
+		"DISPLAY": ":0",
+		"LANG":    "en_NZ.UTF-8",
+	}
+	expected := []string{"DISPLAY=:0", "HOME=/home/alice", "LANG=en_NZ.UTF-8", "PATH=/usr/bin:/bin"}
+	for i := 0; i < 10; i++ {
+		env := BuildEnviron(environ)
+		if !reflect.DeepEqual(env, expected) {
+			t.Fatalf("BuildEnviron returned %v, expected %v", env, expected)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// errFingerprintMismatch is returned for a worker pod which was started
+// differently from how the current run would start it.
+var errFingerprintMismatch = errors.New("fingerprint mismatch")
+
+// workerFingerprint returns a digest of everything which determines how
+// a worker pod is started, so that a worker pod is reused only by a run
+// which would have started an identical pod.
+func (r *RunnerT) workerFingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "image %s\n", CanonicalImageName(r.image))
+	fmt.Fprintf(h, "digest %s\n", r.config.pinnedDigest(r.image))
+	for _, class := range []string{GeneralClass, RunClass, ImageClass} {
+		fmt.Fprintf(h, "%s %q\n", class, r.fragments.formatOptions(r.mode, class))
+	}
+	for _, vol := range r.volumes() {
+		fmt.Fprintf(h, "volume %q %q %q\n", vol.Name, vol.Volume, vol.Mount)
+	}
+	environ := r.environmentTemplates()
+	for _, name := range sortedKeys(environ) {
+		fmt.Fprintf(h, "environment %q %q\n", name, environ[name])
+	}
+	// pids-limit and nofile are applied by rkt-run-slave on each enter, so are not here
+	fmt.Fprintf(h, "limits %s %s\n", r.limits.CPU, r.limits.Memory)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// environmentTemplates returns the pod environment as configured, before
+// expansion, since the expanded values may differ on every run, such as
+// DISPLAY, and are updated by environment-update rather than a new pod.
+func (r *RunnerT) environmentTemplates() map[string]string {
+	blacklist := r.environmentBlacklist()
+	environ := make(map[string]string)
+	for name, value := range r.config.Environment {
+		if !blacklist[name] {
+			environ[name] = value
+		}
+	}
+	if r.alias != nil {
+		for name, value := range r.config.Alias[r.alias.name].Environment {
+			if !blacklist[name] {
+				environ[name] = value
+			}
+		}
+	}
+	return environ
+}
+
+// writeFingerprint records the fingerprint of a new worker pod
+func (w *Worker) writeFingerprint(uuid string) error {
+	return ioutil.WriteFile(workerFingerprintPath(uuid), []byte(w.fingerprint+"\n"), 0644)
+}
+
+// checkFingerprint returns errFingerprintMismatch unless the worker pod
+// was started with our fingerprint.  Pods without a fingerprint never match.
+func (w *Worker) checkFingerprint(uuid string) error {
+	fingerprint, err := ioutil.ReadFile(workerFingerprintPath(uuid))
+	if err != nil || strings.TrimSpace(string(fingerprint)) != w.fingerprint {
+		return errFingerprintMismatch
+	}
+	return nil
+}
@@ -18,6 +18,8 @@ import (
 	"bytes"
 	"fmt"
 	"text/template"
+
+	"github.com/BurntSushi/toml"
 )
 
 type aliasFragmentsT struct {
@@ -30,6 +32,7 @@ type fragmentsT struct {
 	Environment map[string]string
 	Options     ModeOptionsT
 	Volume      map[string]VolumeT
+	VolumeOrder []string
 	Alias       map[string]aliasFragmentsT
 }
 
@@ -49,14 +52,29 @@ func expandFragments(desc, tstr string, vars map[string]string) (string, error)
 }
 
 func GetFragments(c *configT, vars map[string]string, f *fragmentsT) error {
-	var err error
+	var firstErr error
+	getFragments(c, vars, f, func(key toml.Key, err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	})
+	return firstErr
+}
 
-	f.Environment = make(map[string]string)
-	for envKey, envVal := range c.Environment {
-		s, err := expandFragments(fmt.Sprintf("environment %v", envKey), envVal, vars)
+// getFragments expands all the fragments, reporting each failure
+// against its config key, and carrying on regardless.
+func getFragments(c *configT, vars map[string]string, f *fragmentsT, report func(toml.Key, error)) {
+	expand := func(key toml.Key, desc, tstr string) string {
+		s, err := expandFragments(desc, tstr, vars)
 		if err != nil {
-			return err
+			report(key, err)
 		}
+		return s
+	}
+
+	f.Environment = make(map[string]string)
+	for envKey, envVal := range c.Environment {
+		s := expand(toml.Key{EnvironmentTable, envKey}, fmt.Sprintf("environment %v", envKey), envVal)
 		if s != "" {
 			f.Environment[envKey] = s
 		}
@@ -68,10 +86,7 @@ func GetFragments(c *configT, vars map[string]string, f *fragmentsT) error {
 
 		for class, classOptions := range options {
 			for _, option := range classOptions {
-				s, err := expandFragments(fmt.Sprintf("%s.%s.%s", OptionsTable, mode, class), option, vars)
-				if err != nil {
-					return err
-				}
+				s := expand(toml.Key{OptionsTable, mode, class}, fmt.Sprintf("%s.%s.%s", OptionsTable, mode, class), option)
 				f.Options[mode][class] = append(f.Options[mode][class], s)
 			}
 		}
@@ -80,55 +95,36 @@ func GetFragments(c *configT, vars map[string]string, f *fragmentsT) error {
 
 	f.Volume = make(map[string]VolumeT)
 	for volKey, volVal := range c.Volume {
-		volFrag := VolumeT{OnRequest: volVal.OnRequest}
+		volFrag := VolumeT{OnRequest: volVal.OnRequest, Order: volVal.Order}
 		if volVal.Volume != "" {
-			volFrag.Volume, err = expandFragments(fmt.Sprintf("volume %s volume", volKey), volVal.Volume, vars)
-			if err != nil {
-				return err
-			}
+			volFrag.Volume = expand(toml.Key{VolumeTable, volKey, "volume"}, fmt.Sprintf("volume %s volume", volKey), volVal.Volume)
 		}
 		if volVal.Mount != "" {
-			volFrag.Mount, err = expandFragments(fmt.Sprintf("volume %s mount", volKey), volVal.Mount, vars)
-			if err != nil {
-				return err
-			}
+			volFrag.Mount = expand(toml.Key{VolumeTable, volKey, "mount"}, fmt.Sprintf("volume %s mount", volKey), volVal.Mount)
 		}
 		f.Volume[volKey] = volFrag
 	}
+	f.VolumeOrder = c.volumeNames()
 
 	f.Alias = make(map[string]aliasFragmentsT)
 	for aliasKey, aliasVal := range c.Alias {
 		envMap := make(map[string]string)
 		for envKey, envVal := range aliasVal.Environment {
-			envFrag, err := expandFragments(fmt.Sprintf("alias %s environ %s", aliasKey, envKey), envVal, vars)
-			if err != nil {
-				return err
-			}
-			envMap[envKey] = envFrag
+			envMap[envKey] = expand(toml.Key{AliasTable, aliasKey, EnvironmentTable, envKey}, fmt.Sprintf("alias %s environ %s", aliasKey, envKey), envVal)
 		}
 
 		passwd := make([]string, len(aliasVal.Passwd), len(aliasVal.Passwd))
 		for i, passwdVal := range aliasVal.Passwd {
-			passwdFrag, err := expandFragments(fmt.Sprintf("alias %s passwd %d", aliasKey, i), passwdVal, vars)
-			if err != nil {
-				return err
-			}
-			passwd[i] = passwdFrag
+			passwd[i] = expand(toml.Key{AliasTable, aliasKey, "passwd"}, fmt.Sprintf("alias %s passwd %d", aliasKey, i), passwdVal)
 		}
 
 		group := make([]string, len(aliasVal.Group), len(aliasVal.Group))
 		for i, groupVal := range aliasVal.Group {
-			groupFrag, err := expandFragments(fmt.Sprintf("alias %s group %d", aliasKey, i), groupVal, vars)
-			if err != nil {
-				return err
-			}
-			group[i] = groupFrag
+			group[i] = expand(toml.Key{AliasTable, aliasKey, "group"}, fmt.Sprintf("alias %s group %d", aliasKey, i), groupVal)
 		}
 
 		f.Alias[aliasKey] = aliasFragmentsT{Environment: envMap, Passwd: passwd, Group: group}
 	}
-
-	return nil
 }
 
 func (f *fragmentsT) getEnvironment(alias string, blacklist map[string]bool) map[string]string {
@@ -171,22 +167,14 @@ func (f *fragmentsT) formatOptions(mode, class string) []string {
 	return s
 }
 
-func (f *fragmentsT) formatVolumes(requested map[string]bool) []string {
-	var s []string
-	for key, vol := range f.Volume {
-		if vol.Volume != "" && (!vol.OnRequest || requested[key]) {
-			s = append(s, "--volume", fmt.Sprintf("%s,%s", key, vol.Volume))
-		}
-	}
-	return s
-}
-
-func (f *fragmentsT) formatMounts(requested map[string]bool) []string {
-	var s []string
-	for key, vol := range f.Volume {
-		if vol.Mount != "" && (!vol.OnRequest || requested[key]) {
-			s = append(s, "--mount", fmt.Sprintf("volume=%s,%s", key, vol.Mount))
+// volumes returns the default volumes, and those requested, in order
+func (f *fragmentsT) volumes(requested map[string]bool) []VolumeSpec {
+	var volumes []VolumeSpec
+	for _, key := range f.VolumeOrder {
+		vol := f.Volume[key]
+		if !vol.OnRequest || requested[key] {
+			volumes = append(volumes, VolumeSpec{Name: key, Volume: vol.Volume, Mount: vol.Mount})
 		}
 	}
-	return s
+	return volumes
 }
@@ -0,0 +1,138 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const podStartedLayout = "2006-01-02 15:04:05.9 -0700 MST"
+
+// lockPodExclusive attempts to acquire an exclusive lock on the pod,
+// without blocking, which succeeds only if there are no users of the pod.
+func lockPodExclusive(uuid string) (*os.File, error) {
+	podlock, err := os.Open(WorkerPodDir(uuid))
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(podlock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		podlock.Close()
+		return nil, err
+	}
+	return podlock, nil
+}
+
+func stopPod(runtime Runtime, pod *VisitedPod, podState string) error {
+	err := runtime.Stop(pod.UUID)
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "stop %s %s\n", podState, pod)
+	}
+	return err
+}
+
+// CollectWorkerPods stops all running worker pods which have been idle
+// for longer than the grace period, since they started or were last used,
+// and are not in use, and removes any spurious worker pod directories.
+func CollectWorkerPods(runtime Runtime, gracePeriod time.Duration, dryRun bool) error {
+	runningWorkerPods, err := GetWorkerPodUuids(false)
+	if err != nil {
+		return err
+	}
+
+	var anyErr error
+	err = runtime.VisitPods(func(pod *VisitedPod) bool {
+		if pod.State == "running" && strings.HasPrefix(pod.AppName, WORKER_APPNAME_PREFIX) {
+			runningWorkerPods[pod.UUID] = true
+			stop := false
+			podState := "idle"
+			var podlock *os.File
+			var expired, used bool
+			var err error
+			if pod.Started != "" {
+				started, err := time.Parse(podStartedLayout, pod.Started)
+				if err != nil {
+					anyErr = fmt.Errorf("failed to parse start time for pod %s: %v", pod.UUID, err)
+					return false
+				}
+				active := lastActive(pod.UUID, started)
+				used = active.After(started)
+				expiry := active.Add(gracePeriod)
+				expired = time.Now().After(expiry)
+			}
+			if !expired {
+				if used {
+					fmt.Fprintf(os.Stderr, "skip recent %s\n", pod)
+				} else {
+					fmt.Fprintf(os.Stderr, "skip baby %s\n", pod)
+				}
+			} else {
+				podlock, err = lockPodExclusive(pod.UUID)
+				if err != nil {
+					errno, isErrno := err.(syscall.Errno)
+					if isErrno && errno == syscall.EAGAIN {
+						fmt.Fprintf(os.Stderr, "skip busy %s\n", pod)
+					} else {
+						_, isPathError := err.(*os.PathError)
+						if isPathError {
+							// shouldn't happen, so clean up the mess
+							stop = true
+							podState = "orphaned"
+						} else {
+							fmt.Fprintf(os.Stderr, "warning: %s %v %T\n", pod, err, err)
+						}
+					}
+				} else if podlock != nil {
+					stop = true
+				}
+			}
+			if stop {
+				if dryRun {
+					fmt.Fprintf(os.Stderr, "stop %s %s\n", podState, pod)
+				} else {
+					err = stopPod(runtime, pod, podState)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "warning: %s %v\n", pod, err)
+					} else {
+						os.RemoveAll(WorkerPodDir(pod.UUID))
+					}
+				}
+				if podlock != nil {
+					podlock.Close()
+				}
+			}
+		}
+		return true
+	})
+	if anyErr != nil {
+		return anyErr
+	}
+
+	// clean up any worker pod directories that don't have running pods
+	for uuid, running := range runningWorkerPods {
+		if !running {
+			fmt.Fprintf(os.Stderr, "warning: spurious lockdir for pod %s, removing\n", uuid)
+			if !dryRun {
+				os.RemoveAll(WorkerPodDir(uuid))
+			}
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollectWorkerPods(t *testing.T) {
+	const (
+		idleUUID     = "11111111-1111-1111-1111-111111111111"
+		busyUUID     = "22222222-2222-2222-2222-222222222222"
+		babyUUID     = "33333333-3333-3333-3333-333333333333"
+		orphanUUID   = "44444444-4444-4444-4444-444444444444"
+		otherUUID    = "55555555-5555-5555-5555-555555555555"
+		spuriousUUID = "66666666-6666-6666-6666-666666666666"
+		image        = "example.com/tools/busybox:1.0"
+	)
+
+	h := newHarness(t)
+	h.setMasterRoot()
+	for _, uuid := range []string{idleUUID, busyUUID, babyUUID, spuriousUUID} {
+		h.createWorkerPodDir(uuid)
+	}
+
+	old := time.Now().Add(-2 * time.Hour).Format(podStartedLayout)
+	young := time.Now().Format(podStartedLayout)
+	h.script("list",
+		listLine(idleUUID, "rktrunner-alice", image, "running", old)+
+			listLine(busyUUID, "rktrunner-bob", image, "running", old)+
+			listLine(babyUUID, "rktrunner-carol", image, "running", young)+
+			listLine(orphanUUID, "rktrunner-dave", image, "running", old)+
+			listLine(otherUUID, "someone-else", image, "running", old), 0)
+
+	busy := &Worker{}
+	err := busy.LockPod(busyUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Podlock.Close()
+
+	err = CollectWorkerPods(NewRktRuntime(h.rkt), time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "stop", idleUUID},
+		[]string{"rkt", "stop", orphanUUID},
+	)
+	for uuid, expected := range map[string]bool{
+		idleUUID:     false,
+		busyUUID:     true,
+		babyUUID:     true,
+		spuriousUUID: false,
+	} {
+		if exists(filepath.Join(h.root, podPrefix+uuid)) != expected {
+			t.Errorf("worker pod dir for %s exists is %v, expected %v", uuid, !expected, expected)
+		}
+	}
+}
+
+func TestCollectWorkerPodsConfiguredRuntime(t *testing.T) {
+	h := newHarness(t)
+	h.setMasterRoot()
+	h.writeConfig(testConfig)
+
+	runtime, err := NewRuntimeFromConfigFile(h.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CollectWorkerPods(runtime, time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.expectInvocations([]string{"rkt", "list", "--full", "--no-legend"})
+}
+
+func TestCollectWorkerPodsNoMasterRoot(t *testing.T) {
+	h := newHarness(t)
+	h.setMasterRoot()
+	masterRoot = filepath.Join(h.root, "nonexistent")
+
+	err := CollectWorkerPods(NewRktRuntime(h.rkt), time.Hour, false)
+	if err == nil {
+		t.Errorf("expected error for missing %s", masterRoot)
+	}
+	h.expectInvocations()
+}
+
+func TestCollectWorkerPodsDryRun(t *testing.T) {
+	const uuid = "11111111-1111-1111-1111-111111111111"
+
+	h := newHarness(t)
+	h.setMasterRoot()
+	h.createWorkerPodDir(uuid)
+	h.script("list", listLine(uuid, "rktrunner-alice", "example.com/tools/busybox:1.0", "running", time.Now().Add(-2*time.Hour).Format(podStartedLayout)), 0)
+
+	err := CollectWorkerPods(NewRktRuntime(h.rkt), time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.expectInvocations([]string{"rkt", "list", "--full", "--no-legend"})
+
+	// the pod is left unlocked
+	podlock, err := lockPodExclusive(uuid)
+	if err != nil {
+		t.Fatalf("pod left locked: %v", err)
+	}
+	podlock.Close()
+}
+
+func TestCollectWorkerPodsLastUse(t *testing.T) {
+	const (
+		idleUUID   = "11111111-1111-1111-1111-111111111111"
+		recentUUID = "22222222-2222-2222-2222-222222222222"
+		endedUUID  = "33333333-3333-3333-3333-333333333333"
+		image      = "example.com/tools/busybox:1.0"
+	)
+
+	h := newHarness(t)
+	h.setMasterRoot()
+	for _, uuid := range []string{idleUUID, recentUUID, endedUUID} {
+		h.createWorkerPodDir(uuid)
+	}
+
+	old := time.Now().Add(-3 * time.Hour).Format(podStartedLayout)
+	h.script("list",
+		listLine(idleUUID, "rktrunner-alice", image, "running", old)+
+			listLine(recentUUID, "rktrunner-bob", image, "running", old)+
+			listLine(endedUUID, "rktrunner-carol", image, "running", old), 0)
+
+	// idle: used long ago
+	w := &Worker{}
+	err := w.LockPod(idleUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Release()
+	for _, path := range []string{workerLastUsePath(idleUUID), workerSessionEndPath(idleUUID)} {
+		err = ioutil.WriteFile(path, []byte(time.Now().Add(-2*time.Hour).Format(time.RFC3339Nano)+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// recent: a session started lately, but never ended
+	w = &Worker{}
+	err = w.LockPod(recentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Podlock.Close()
+
+	// ended: a long session ended lately
+	err = writeTimestamp(workerSessionEndPath(endedUUID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CollectWorkerPods(NewRktRuntime(h.rkt), time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "stop", idleUUID},
+	)
+	for uuid, expected := range map[string]bool{
+		idleUUID:   false,
+		recentUUID: true,
+		endedUUID:  true,
+	} {
+		if exists(filepath.Join(h.root, podPrefix+uuid)) != expected {
+			t.Errorf("worker pod dir for %s exists is %v, expected %v", uuid, !expected, expected)
+		}
+	}
+}
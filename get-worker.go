@@ -0,0 +1,61 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// GetWorker returns a worker pod for the image, or alias, for the user
+// with the given uid, as configured in the site-wide config file.  An
+// existing worker pod is reused if possible, otherwise a new one is
+// started, as by rkt-run --prepare.  Either way, the pod is locked
+// against garbage collection until the caller calls Release.
+// Config templates and the pod environment use the caller's environment.
+// This requires root, and worker-pods in the config file.
+func GetWorker(image string, uid int) (*Worker, error) {
+	return getWorker(DefaultConfigFile, image, uid)
+}
+
+func getWorker(configFile, image string, uid int) (w *Worker, err error) {
+	if getuid() != 0 || geteuid() != 0 {
+		return nil, ErrNotRoot
+	}
+
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user %d: %v", uid, err)
+	}
+
+	r := newRunner(&RunRequest{Image: image, Prepare: true, Environ: os.Environ()}, u, configFile)
+	defer func() {
+		r.finish(err)
+	}()
+
+	err = r.setup()
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.launch()
+	if err != nil {
+		r.worker.Release()
+		return nil, err
+	}
+	return r.worker, nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// setUid makes getWorker behave as if run by uid, until the test ends.
+func (h *harness) setUid(uid int) {
+	savedUid, savedEuid := getuid, geteuid
+	getuid = func() int { return uid }
+	geteuid = func() int { return uid }
+	h.t.Cleanup(func() { getuid, geteuid = savedUid, savedEuid })
+}
+
+// setRoot makes getWorker behave as if run by root, until the test ends.
+func (h *harness) setRoot() {
+	h.setMasterRoot()
+	h.setUid(0)
+}
+
+func TestGetWorkerNew(t *testing.T) {
+	h := newHarness(t)
+	h.setRoot()
+	h.writeConfig(testWorkerConfig)
+	h.script("status", "state=running\n", 0)
+	uid, err := strconv.Atoi(h.user.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := getWorker(h.config, "grep", uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Release()
+	if w.UUID != fakeUUID || w.AppName != WORKER_APPNAME_PREFIX+h.user.Username {
+		t.Errorf("unexpected worker %s for %s", w.UUID, w.AppName)
+	}
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "--insecure-options=image", "run",
+			"--uuid-file-save", "$ROOT/runner-$PID/uuid",
+			"--set-env-file", "$ROOT/runner-$PID/env",
+			"--volume", "rktrunner-bin,kind=host,source=$SLAVEDIR",
+			"example.com/tools/busybox:1.0",
+			"--name", "rktrunner-$USER",
+			"--mount", "volume=rktrunner-bin,target=/usr/lib/rktrunner",
+			"--user=" + h.user.Uid,
+			"--exec", "/usr/lib/rktrunner/rkt-run-slave", "--", "--wait"},
+		[]string{"rkt", "status", fakeUUID},
+	)
+	if !exists(filepath.Join(h.root, podPrefix+fakeUUID)) {
+		t.Errorf("worker pod dir not created")
+	}
+}
+
+func TestGetWorkerExisting(t *testing.T) {
+	h := newHarness(t)
+	h.setRoot()
+	h.writeConfig(testWorkerConfig)
+	h.createWorkerPodDirWithFingerprint(fakeUUID, h.workerFingerprint(RunRequest{Image: "busybox_", Prepare: true}))
+	h.script("list", listLine(fakeUUID, WORKER_APPNAME_PREFIX+h.user.Username, "example.com/tools/busybox:1.0", "running", ""), 0)
+	h.script("cat-manifest", podManifest(h.user.Uid), 0)
+	uid, err := strconv.Atoi(h.user.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := getWorker(h.config, "busybox_", uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Release()
+	if w.UUID != fakeUUID {
+		t.Errorf("found worker %s, expected %s", w.UUID, fakeUUID)
+	}
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "cat-manifest", fakeUUID},
+	)
+}
+
+func TestGetWorkerNotRoot(t *testing.T) {
+	h := newHarness(t)
+	h.setMasterRoot()
+	h.setUid(1000)
+	h.writeConfig(testWorkerConfig)
+
+	_, err := getWorker(h.config, "grep", 0)
+	if err != ErrNotRoot {
+		t.Errorf("expected %v, got %v", ErrNotRoot, err)
+	}
+	h.expectInvocations()
+}
+
+func TestGetWorkerWithoutWorkerPods(t *testing.T) {
+	h := newHarness(t)
+	h.setRoot()
+	h.writeConfig(testConfig)
+	uid, err := strconv.Atoi(h.user.Uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = getWorker(h.config, "grep", uid)
+	if err == nil {
+		t.Errorf("expected failure without worker-pods")
+	}
+	h.expectInvocations()
+}
@@ -0,0 +1,192 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Each directory in testdata/golden is a test case, comprising:
+//
+//	rktrunner.toml  config file, to which the rkt path is prepended
+//	args            rkt-run arguments, one per line
+//	passwd          passwd entry for the user running rkt-run
+//	environ         (optional) environment, one name=value per line
+//
+// and the expected command lines, fetch.golden, run.golden and
+// enter.golden, which are absent if no such command is expected.
+var update = flag.Bool("update", false, "update golden files")
+
+var goldenCommands = []string{"fetch", "run", "enter"}
+
+// parsePasswd returns the user for a line in passwd file format.
+func parsePasswd(line string) (*user.User, error) {
+	fields := strings.Split(strings.TrimSpace(line), ":")
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("bad passwd entry: %s", line)
+	}
+	return &user.User{
+		Username: fields[0],
+		Uid:      fields[2],
+		Gid:      fields[3],
+		Name:     fields[4],
+		HomeDir:  fields[5],
+	}, nil
+}
+
+// printCommands prints the commands the runner would execute, one per line,
+// each prefixed by its name.
+func printCommands(r *RunnerT, w io.Writer) error {
+	if r.fetchCommand != nil {
+		fmt.Fprintf(w, "fetch ")
+		r.fetchCommand.Print(w)
+	}
+	if r.runCommand != nil {
+		fmt.Fprintf(w, "run ")
+		r.runCommand.Print(w)
+	}
+	if r.worker != nil && !r.request.Prepare {
+		err := r.buildEnterCommand()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "enter ")
+		r.enterCommand.Print(w)
+	}
+	return nil
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func TestGolden(t *testing.T) {
+	cases, err := filepath.Glob(filepath.Join("testdata", "golden", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no golden test cases")
+	}
+	for _, dir := range cases {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			testGolden(t, dir)
+		})
+	}
+}
+
+func testGolden(t *testing.T, dir string) {
+	h := newHarness(t)
+
+	config, err := ioutil.ReadFile(filepath.Join(dir, "rktrunner.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.writeConfig(string(config))
+
+	args, err := readLines(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwd, err := ioutil.ReadFile(filepath.Join(dir, "passwd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.user, err = parsePasswd(string(passwd))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environ, err := readLines(filepath.Join(dir, "environ"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	h.environ = append([]string{
+		fmt.Sprintf("PATH=%s", os.Getenv("PATH")),
+		fmt.Sprintf("%s=%s", testUserEnv, strings.TrimSpace(string(passwd))),
+		fmt.Sprintf("%s=1", testPrintCommandsEnv),
+	}, environ...)
+
+	cmd, stderr := h.rktRunCommand(args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("rkt-run %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+
+	actual := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) == 2 {
+			actual[fields[0]] = h.normalizePaths(fields[1]) + "\n"
+		}
+	}
+
+	for _, name := range goldenCommands {
+		path := filepath.Join(dir, name+".golden")
+		command, isActual := actual[name]
+		if *update {
+			if isActual {
+				err = ioutil.WriteFile(path, []byte(command), 0644)
+			} else {
+				err = os.Remove(path)
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		expected, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		switch {
+		case isActual && err != nil:
+			t.Errorf("unexpected %s command:\n%s", name, command)
+		case !isActual && err == nil:
+			t.Errorf("missing %s command, expected:\n%s", name, expected)
+		case isActual && command != string(expected):
+			t.Errorf("%s command:\n%s\nexpected:\n%s", name, command, expected)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tesujimath/rktrunner/internal/fakerkt"
+)
+
+// The test binary doubles as rkt-run and as fake rkt, so that the real
+// code paths, including exec, can be driven without root or containers.
+const testRunnerRootEnv = "RKTRUNNER_TEST_ROOT"
+
+// testUserEnv is a passwd line for the user to run rkt-run as
+const testUserEnv = "RKTRUNNER_TEST_USER"
+
+// testGroupsEnv is a comma-separated list of the groups of the user
+const testGroupsEnv = "RKTRUNNER_TEST_GROUPS"
+
+// testPrintCommandsEnv makes rkt-run print its commands instead of executing
+const testPrintCommandsEnv = "RKTRUNNER_TEST_PRINT_COMMANDS"
+
+// testConfigEnv is the config file, used as the default rather than by
+// --config, which the user may not be permitted
+const testConfigEnv = "RKTRUNNER_TEST_CONFIG"
+
+const fakeUUID = fakerkt.DefaultUUID
+
+func TestMain(m *testing.M) {
+	if dir := os.Getenv(fakerkt.DirEnv); dir != "" {
+		os.Exit(fakerkt.Main(dir, os.Args))
+	}
+	if root := os.Getenv(testRunnerRootEnv); root != "" {
+		os.Exit(testRunnerMain(root))
+	}
+	os.Exit(m.Run())
+}
+
+// testRunnerMain is rkt-run, as if run by root, but rooted elsewhere.
+func testRunnerMain(root string) int {
+	masterRoot = root
+	getuid = func() int { return 0 }
+	geteuid = func() int { return 0 }
+	if passwd := os.Getenv(testUserEnv); passwd != "" {
+		currentUser = func() (*user.User, error) {
+			return parsePasswd(passwd)
+		}
+	}
+	if groups, isSet := os.LookupEnv(testGroupsEnv); isSet {
+		userGroups = func(u *user.User) ([]string, error) {
+			return strings.Split(groups, ","), nil
+		}
+	}
+
+	r, err := NewRunner(os.Getenv(testConfigEnv))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rkt-run: %v\n", err)
+		return 1
+	}
+	if os.Getenv(testPrintCommandsEnv) != "" {
+		err = r.prepare()
+		if err == nil {
+			err = printCommands(r, os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "rkt-run: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	r.HandleSignals()
+	err = r.Execute()
+	if err != nil {
+		switch err.(type) {
+		case *exec.ExitError:
+		case *TimeoutError:
+			fmt.Fprintf(os.Stderr, "rkt-run: %v\n", err)
+		default:
+			fmt.Fprintf(os.Stderr, "rkt-run: failed: %v\n", err)
+		}
+		return ExitStatus(err)
+	}
+	return 0
+}
+
+type harness struct {
+	t        *testing.T
+	user     *user.User
+	rkt      string
+	rktDir   string
+	root     string
+	slaveDir string
+	workDir  string
+	config   string
+	environ  []string
+}
+
+func newHarness(t *testing.T) *harness {
+	u, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	h := &harness{
+		t:        t,
+		user:     u,
+		rkt:      filepath.Join(dir, "bin", "rkt"),
+		rktDir:   filepath.Join(dir, "fake-rkt"),
+		root:     filepath.Join(dir, "rktrunner"),
+		slaveDir: filepath.Join(dir, "libexec"),
+		workDir:  filepath.Join(dir, "work"),
+		config:   filepath.Join(dir, "rktrunner.toml"),
+		environ:  os.Environ(),
+	}
+	for _, d := range []string{filepath.Dir(h.rkt), h.rktDir, h.root, h.slaveDir, h.workDir} {
+		err = os.Mkdir(d, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(h.slaveDir, slaveRunner), nil, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fakerkt.WriteScript(h.rkt, program, h.rktDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// writeConfig writes the config file, with the rkt path prepended,
+// and $SLAVEDIR replaced by the exec-slave-dir.
+func (h *harness) writeConfig(body string) {
+	body = strings.Replace(body, "$SLAVEDIR", h.slaveDir, -1)
+	err := ioutil.WriteFile(h.config, []byte(fmt.Sprintf("rkt = %q\n%s", h.rkt, body)), 0644)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// script sets the canned output and exit status for a fake rkt subcommand.
+func (h *harness) script(subcommand, out string, exit int) {
+	err := ioutil.WriteFile(filepath.Join(h.rktDir, subcommand+".out"), []byte(out), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(h.rktDir, subcommand+".exit"), []byte(fmt.Sprintf("%d\n", exit)), 0644)
+	}
+	if err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// rktRunCommand returns the command to run rkt-run with the config file,
+// with stderr captured.
+func (h *harness) rktRunCommand(args ...string) (*exec.Cmd, *bytes.Buffer) {
+	program, err := os.Executable()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	cmd := exec.Command(program, args...)
+	cmd.Args[0] = "rkt-run"
+	cmd.Dir = h.workDir
+	cmd.Env = append(h.environ, fmt.Sprintf("%s=%s", testRunnerRootEnv, h.root), fmt.Sprintf("%s=%s", testConfigEnv, h.config))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	return cmd, &stderr
+}
+
+// rktRun runs rkt-run with the config file, returning stderr and exit status.
+func (h *harness) rktRun(args ...string) (string, int) {
+	cmd, stderr := h.rktRunCommand(args...)
+	err := cmd.Run()
+	if err != nil {
+		exitErr, isExitErr := err.(*exec.ExitError)
+		if !isExitErr {
+			h.t.Fatal(err)
+		}
+		return stderr.String(), exitErr.ExitCode()
+	}
+	return stderr.String(), 0
+}
+
+var runnerPidRegexp = regexp.MustCompile(`runner-[0-9]+`)
+
+// invocations returns the fake rkt argv so far, with paths made
+// independent of the test location and process id.
+func (h *harness) invocations() [][]string {
+	invocations, err := fakerkt.ReadInvocations(h.rktDir)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	for _, argv := range invocations {
+		for i := range argv {
+			argv[i] = h.normalize(argv[i])
+		}
+	}
+	return invocations
+}
+
+func (h *harness) normalize(s string) string {
+	s = h.normalizePaths(s)
+	s = strings.Replace(s, WORKER_APPNAME_PREFIX+h.user.Username, WORKER_APPNAME_PREFIX+"$USER", -1)
+	return strings.Replace(s, "/home/"+h.user.Username, "/home/$USER", -1)
+}
+
+// normalizePaths makes s independent of the test location and process id.
+func (h *harness) normalizePaths(s string) string {
+	s = strings.Replace(s, h.rkt, "$RKT", -1)
+	s = strings.Replace(s, h.root, "$ROOT", -1)
+	s = strings.Replace(s, h.slaveDir, "$SLAVEDIR", -1)
+	s = strings.Replace(s, h.workDir, "$CWD", -1)
+	return runnerPidRegexp.ReplaceAllString(s, "runner-$$PID")
+}
+
+// denormalize substitutes the current user into s.
+func (h *harness) denormalize(s string) string {
+	s = strings.Replace(s, "$USER", h.user.Username, -1)
+	return strings.Replace(s, "$UID", h.user.Uid, -1)
+}
+
+func (h *harness) expectInvocations(expected ...[]string) {
+	actual := h.invocations()
+	if !reflect.DeepEqual(actual, expected) {
+		h.t.Errorf("rkt invocations:\n%s\nexpected:\n%s", formatInvocations(actual), formatInvocations(expected))
+	}
+}
+
+func formatInvocations(invocations [][]string) string {
+	var b bytes.Buffer
+	for _, argv := range invocations {
+		fmt.Fprintf(&b, "  %s\n", strings.Join(argv, " "))
+	}
+	return b.String()
+}
+
+// podManifest returns a pod manifest for a single app, run as uid.
+func podManifest(uid string) string {
+	return fmt.Sprintf(`{
+  "acVersion": "1.30.0",
+  "acKind": "PodManifest",
+  "apps": [
+    {
+      "name": "worker",
+      "image": {
+        "name": "example.com/tools/busybox",
+        "id": "sha512-%s"
+      },
+      "app": {
+        "exec": ["/usr/lib/rktrunner/rkt-run-slave", "--wait"],
+        "user": "%s",
+        "group": "%s"
+      }
+    }
+  ]
+}
+`, strings.Repeat("0", 128), uid, uid)
+}
+
+// listLine returns a line of rkt list --full --no-legend output.
+func listLine(uuid, appName, image, state, started string) string {
+	return strings.Join([]string{uuid, appName, image, "sha512-0123456789ab", state, started, started, ""}, "\t") + "\n"
+}
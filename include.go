@@ -0,0 +1,175 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+)
+
+const AliasTable = "alias"
+const EnvironmentTable = "environment"
+const AutoImagePrefixTable = "auto-image-prefix"
+
+// includeT is the part of the config which may be defined in an
+// included file.
+type includeT struct {
+	AutoImagePrefix map[string]string `toml:"auto-image-prefix"`
+	Environment     map[string]string `toml:"environment"`
+	Volume          map[string]VolumeT
+	Alias           map[string]ImageAliasT
+}
+
+// sourceKey identifies an entry in one of the mergeable tables
+func sourceKey(table, name string) string {
+	return fmt.Sprintf("%s.%s", table, name)
+}
+
+// Source returns the config file in which the named entry of table was defined.
+func (c *configT) Source(table, name string) string {
+	return c.sources[sourceKey(table, name)]
+}
+
+func (c *configT) setSource(table, name, path string) error {
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	key := sourceKey(table, name)
+	previous, isDup := c.sources[key]
+	if isDup {
+		return fmt.Errorf("%s %s defined in both %s and %s", table, name, previous, path)
+	}
+	c.sources[key] = path
+	return nil
+}
+
+// setSources records path as the source of all mergeable table entries
+func (c *configT) setSources(path string, inc *includeT) error {
+	tables := []struct {
+		name string
+		keys []string
+	}{
+		{AutoImagePrefixTable, sortedKeys(inc.AutoImagePrefix)},
+		{EnvironmentTable, sortedKeys(inc.Environment)},
+		{VolumeTable, sortedKeys(inc.Volume)},
+		{AliasTable, sortedKeys(inc.Alias)},
+	}
+	for _, table := range tables {
+		for _, key := range table.keys {
+			err := c.setSource(table.name, key, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// includedFiles returns the files matching the include patterns, which
+// are relative to the directory of the main config file, in order.
+func (c *configT) includedFiles(path string) ([]string, error) {
+	var files []string
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %v", pattern, err)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// mergeInclude merges the included file into the config,
+// failing on any conflict.  Keys not allowed in an included file
+// are recorded for validation.
+func (c *configT) mergeInclude(path string) error {
+	var inc includeT
+	md, err := toml.DecodeFile(path, &inc)
+	if err != nil {
+		return fmt.Errorf("%s %v", path, err)
+	}
+	for _, key := range md.Undecoded() {
+		c.includeUndecoded = append(c.includeUndecoded, includeKeyT{path: path, key: key})
+	}
+
+	err = c.setSources(path, &inc)
+	if err != nil {
+		return err
+	}
+
+	if len(inc.AutoImagePrefix) > 0 && c.AutoImagePrefix == nil {
+		c.AutoImagePrefix = make(map[string]string)
+	}
+	for key, val := range inc.AutoImagePrefix {
+		c.AutoImagePrefix[key] = val
+	}
+
+	if len(inc.Environment) > 0 && c.Environment == nil {
+		c.Environment = make(map[string]string)
+	}
+	for key, val := range inc.Environment {
+		c.Environment[key] = val
+	}
+
+	if len(inc.Volume) > 0 && c.Volume == nil {
+		c.Volume = make(map[string]VolumeT)
+	}
+	for key, val := range inc.Volume {
+		c.Volume[key] = val
+	}
+	c.volumeOrder = append(c.volumeOrder, tableOrder(md, VolumeTable)...)
+
+	if len(inc.Alias) > 0 && c.Alias == nil {
+		c.Alias = make(map[string]ImageAliasT)
+	}
+	for key, val := range inc.Alias {
+		c.Alias[key] = val
+	}
+
+	return nil
+}
+
+// mergeIncludes merges all the included files into the config,
+// which was read from path.
+func (c *configT) mergeIncludes(path string) error {
+	err := c.setSources(path, &includeT{
+		AutoImagePrefix: c.AutoImagePrefix,
+		Environment:     c.Environment,
+		Volume:          c.Volume,
+		Alias:           c.Alias,
+	})
+	if err != nil {
+		return err
+	}
+
+	files, err := c.includedFiles(path)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err = c.mergeInclude(file)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fakerkt is a scriptable stand-in for rkt, for testing without
+// root or containers.
+//
+// All behaviour is controlled by files in a directory.  Every invocation
+// appends its argv as a JSON array to argv.log in that directory, with
+// argv[0] reduced to its basename.  For a subcommand such as list, status
+// or cat-manifest, the contents of the file <subcommand>.out (if any) are
+// written to stdout, and the exit status is read from <subcommand>.exit
+// (default 0).  For run, the --uuid-file-save file is written with the
+// contents of the file uuid, or DefaultUUID.
+//
+// If the file <subcommand>.hang exists, then rather than exiting, the
+// subcommand creates <subcommand>.ready and waits for a signal, appending
+// the number of each signal received to signals.log.  It exits with
+// status 128+n on receipt of SIGTERM or SIGHUP, unless n is listed in the
+// hang file, in which case the signal is ignored.
+package fakerkt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DirEnv is the environment variable naming the control directory.
+const DirEnv = "FAKE_RKT_DIR"
+
+// Argv0Env is the environment variable which overrides argv[0],
+// as set by the wrapper script.
+const Argv0Env = "FAKE_RKT_ARGV0"
+
+const ArgvLog = "argv.log"
+
+const SignalLog = "signals.log"
+
+const DefaultUUID = "00000000-0000-0000-0000-000000000000"
+
+// subcommand returns the first non-option argument, skipping
+// general options which precede it.
+func subcommand(args []string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
+}
+
+func optionValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return arg[len(name)+1:]
+		}
+	}
+	return ""
+}
+
+func logArgv(dir string, argv []string) error {
+	f, err := os.OpenFile(filepath.Join(dir, ArgvLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := json.Marshal(argv)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%s\n", b)
+	return err
+}
+
+func saveUUID(dir, path string) error {
+	uuid, err := ioutil.ReadFile(filepath.Join(dir, "uuid"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		uuid = []byte(DefaultUUID)
+	}
+	return ioutil.WriteFile(path, uuid, 0644)
+}
+
+func exitStatus(dir, sub string) (int, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, sub+".exit"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// hang waits for signals as described above, returning the exit status
+func hang(dir, sub string, ignore []byte) (int, error) {
+	ignored := make(map[int]bool)
+	for _, field := range strings.Fields(string(ignore)) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, err
+		}
+		ignored[n] = true
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
+	err := ioutil.WriteFile(filepath.Join(dir, sub+".ready"), nil, 0644)
+	if err != nil {
+		return 0, err
+	}
+	for s := range c {
+		n := int(s.(syscall.Signal))
+		f, err := os.OpenFile(filepath.Join(dir, SignalLog), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(f, "%d\n", n)
+		f.Close()
+		if (s == syscall.SIGTERM || s == syscall.SIGHUP) && !ignored[n] {
+			return 128 + n, nil
+		}
+	}
+	return 0, nil
+}
+
+// Main behaves as rkt with the given argv, as scripted by the files
+// in dir, and returns the exit status.
+func Main(dir string, argv []string) int {
+	die := func(err error) int {
+		fmt.Fprintf(os.Stderr, "fake-rkt: %v\n", err)
+		return 254
+	}
+
+	argv = append([]string{}, argv...)
+	if argv0 := os.Getenv(Argv0Env); argv0 != "" {
+		argv[0] = argv0
+	}
+	argv[0] = filepath.Base(argv[0])
+	err := logArgv(dir, argv)
+	if err != nil {
+		return die(err)
+	}
+
+	var args []string
+	if len(argv) > 1 {
+		args = argv[1:]
+	}
+	sub := subcommand(args)
+
+	if sub == "run" {
+		uuidPath := optionValue(args, "--uuid-file-save")
+		if uuidPath != "" {
+			err = saveUUID(dir, uuidPath)
+			if err != nil {
+				return die(err)
+			}
+		}
+	}
+
+	out, err := ioutil.ReadFile(filepath.Join(dir, sub+".out"))
+	if err == nil {
+		os.Stdout.Write(out)
+	} else if !os.IsNotExist(err) {
+		return die(err)
+	}
+
+	ignore, err := ioutil.ReadFile(filepath.Join(dir, sub+".hang"))
+	if err == nil {
+		status, err := hang(dir, sub, ignore)
+		if err != nil {
+			return die(err)
+		}
+		return status
+	} else if !os.IsNotExist(err) {
+		return die(err)
+	}
+
+	status, err := exitStatus(dir, sub)
+	if err != nil {
+		return die(err)
+	}
+	return status
+}
+
+// ReadInvocations returns the argv of each invocation so far, in order.
+func ReadInvocations(dir string) ([][]string, error) {
+	f, err := os.Open(filepath.Join(dir, ArgvLog))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var invocations [][]string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var argv []string
+		err = json.Unmarshal(scanner.Bytes(), &argv)
+		if err != nil {
+			return nil, err
+		}
+		invocations = append(invocations, argv)
+	}
+	return invocations, scanner.Err()
+}
+
+// WriteScript creates an executable shell script at path, which runs
+// program as fake rkt, controlled by dir.  This is useful where rkt is
+// exec'd with an empty environment, e.g. by rkt-run for rkt enter.
+func WriteScript(path, program, dir string) error {
+	script := fmt.Sprintf("#!/bin/sh\n%s=%s %s=\"$0\" exec %s \"$@\"\n", DirEnv, strconv.Quote(dir), Argv0Env, strconv.Quote(program))
+	return ioutil.WriteFile(path, []byte(script), 0755)
+}
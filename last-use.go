@@ -0,0 +1,55 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// writeTimestamp records the current time in the file
+func writeTimestamp(path string) error {
+	return ioutil.WriteFile(path, []byte(time.Now().Format(time.RFC3339Nano)+"\n"), 0644)
+}
+
+// readTimestamp returns the time recorded in the file, or the zero time
+// if there is none.
+func readTimestamp(path string) time.Time {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// lastActive returns when the worker pod was last in use, being the
+// latest of when it started, and when a session last started or ended.
+func lastActive(uuid string, started time.Time) time.Time {
+	active := started
+	for _, t := range []time.Time{
+		readTimestamp(workerLastUsePath(uuid)),
+		readTimestamp(workerSessionEndPath(uuid)),
+	} {
+		if t.After(active) {
+			active = t
+		}
+	}
+	return active
+}
@@ -0,0 +1,282 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// CPUT is an amount of CPU in millicores, written as 2, "1.5" or "500m"
+type CPUT int64
+
+// MemoryT is an amount of memory in bytes, written with an optional
+// decimal or binary suffix, e.g. "512M" or "4Gi"
+type MemoryT int64
+
+// ResourceLimitsT are the limits on resources available to a command,
+// where zero means unlimited.
+type ResourceLimitsT struct {
+	CPU       CPUT    `toml:"cpu"`
+	Memory    MemoryT `toml:"memory"`
+	PidsLimit int     `toml:"pids-limit"`
+	Nofile    int     `toml:"nofile"`
+}
+
+// ResourceMaximumsT are how far users may raise the cpu and memory
+// limits, where zero means not at all.
+type ResourceMaximumsT struct {
+	MaxCPU    CPUT    `toml:"max-cpu"`
+	MaxMemory MemoryT `toml:"max-memory"`
+}
+
+// maximumT is a maximum, and the policy from which it arises
+type maximumT struct {
+	policy string
+	ResourceMaximumsT
+}
+
+var memoryUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	// decimal before binary, for formatting
+	{"T", 1000 * 1000 * 1000 * 1000},
+	{"G", 1000 * 1000 * 1000},
+	{"M", 1000 * 1000},
+	{"k", 1000},
+	{"Ti", 1 << 40},
+	{"Gi", 1 << 30},
+	{"Mi", 1 << 20},
+	{"Ki", 1 << 10},
+}
+
+// parseQuantity parses a non-negative number, multiplied by multiplier
+func parseQuantity(s string, multiplier int64) (int64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("invalid quantity %s", s)
+	}
+	return int64(math.Round(f * float64(multiplier))), nil
+}
+
+func ParseCPU(s string) (CPUT, error) {
+	var millicores int64
+	var err error
+	if strings.HasSuffix(s, "m") {
+		millicores, err = parseQuantity(strings.TrimSuffix(s, "m"), 1)
+	} else {
+		millicores, err = parseQuantity(s, 1000)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu %s", s)
+	}
+	return CPUT(millicores), nil
+}
+
+func (c *CPUT) UnmarshalText(text []byte) error {
+	var err error
+	*c, err = ParseCPU(string(text))
+	return err
+}
+
+func (c CPUT) String() string {
+	if c%1000 == 0 {
+		return fmt.Sprintf("%d", c/1000)
+	}
+	return fmt.Sprintf("%dm", c)
+}
+
+func ParseMemory(s string) (MemoryT, error) {
+	suffix := ""
+	multiplier := int64(1)
+	// match the longest suffix, so Mi isn't taken for M
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(s, unit.suffix) && len(unit.suffix) > len(suffix) {
+			suffix = unit.suffix
+			multiplier = unit.multiplier
+		}
+	}
+	bytes, err := parseQuantity(strings.TrimSuffix(s, suffix), multiplier)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory %s", s)
+	}
+	return MemoryT(bytes), nil
+}
+
+func (m *MemoryT) UnmarshalText(text []byte) error {
+	var err error
+	*m, err = ParseMemory(string(text))
+	return err
+}
+
+func (m MemoryT) String() string {
+	for _, unit := range memoryUnits {
+		if m != 0 && int64(m)%unit.multiplier == 0 {
+			return fmt.Sprintf("%d%s", int64(m)/unit.multiplier, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d", int64(m))
+}
+
+// isEmpty returns whether there are no limits
+func (l *ResourceLimitsT) isEmpty() bool {
+	return l.CPU == 0 && l.Memory == 0 && l.PidsLimit == 0 && l.Nofile == 0
+}
+
+// needsSlave returns whether the limits must be applied by rkt-run-slave
+func (l *ResourceLimitsT) needsSlave() bool {
+	return l.PidsLimit != 0 || l.Nofile != 0
+}
+
+// override returns the limits, with any defined in o taking precedence
+func (l ResourceLimitsT) override(o *ResourceLimitsT) ResourceLimitsT {
+	if o.CPU != 0 {
+		l.CPU = o.CPU
+	}
+	if o.Memory != 0 {
+		l.Memory = o.Memory
+	}
+	if o.PidsLimit != 0 {
+		l.PidsLimit = o.PidsLimit
+	}
+	if o.Nofile != 0 {
+		l.Nofile = o.Nofile
+	}
+	return l
+}
+
+// override returns the maximums, with any defined in o taking precedence
+func (m ResourceMaximumsT) override(o *ResourceMaximumsT) ResourceMaximumsT {
+	if o.MaxCPU != 0 {
+		m.MaxCPU = o.MaxCPU
+	}
+	if o.MaxMemory != 0 {
+		m.MaxMemory = o.MaxMemory
+	}
+	return m
+}
+
+// widen returns the maximums, raised to any greater in o
+func (m ResourceMaximumsT) widen(o *ResourceMaximumsT) ResourceMaximumsT {
+	if o.MaxCPU > m.MaxCPU {
+		m.MaxCPU = o.MaxCPU
+	}
+	if o.MaxMemory > m.MaxMemory {
+		m.MaxMemory = o.MaxMemory
+	}
+	return m
+}
+
+// request returns the limits, changed to those requested, which may be
+// lowered freely, but cpu and memory may be raised only within every
+// applicable maximum, of which there must be at least one.
+func (l ResourceLimitsT) request(requested *ResourceLimitsT, maximums []maximumT) (ResourceLimitsT, error) {
+	if requested.CPU != 0 {
+		if l.CPU != 0 && requested.CPU > l.CPU {
+			raisable := false
+			for _, m := range maximums {
+				if m.MaxCPU != 0 {
+					if requested.CPU > m.MaxCPU {
+						return l, fmt.Errorf("cpus %v exceeds maximum %v for %s", requested.CPU, m.MaxCPU, m.policy)
+					}
+					raisable = true
+				}
+			}
+			if !raisable {
+				return l, fmt.Errorf("cpus %v exceeds limit %v", requested.CPU, l.CPU)
+			}
+		}
+		l.CPU = requested.CPU
+	}
+	if requested.Memory != 0 {
+		if l.Memory != 0 && requested.Memory > l.Memory {
+			raisable := false
+			for _, m := range maximums {
+				if m.MaxMemory != 0 {
+					if requested.Memory > m.MaxMemory {
+						return l, fmt.Errorf("memory %v exceeds maximum %v for %s", requested.Memory, m.MaxMemory, m.policy)
+					}
+					raisable = true
+				}
+			}
+			if !raisable {
+				return l, fmt.Errorf("memory %v exceeds limit %v", requested.Memory, l.Memory)
+			}
+		}
+		l.Memory = requested.Memory
+	}
+	if requested.PidsLimit != 0 {
+		if l.PidsLimit != 0 && requested.PidsLimit > l.PidsLimit {
+			return l, fmt.Errorf("pids-limit %d exceeds limit %d", requested.PidsLimit, l.PidsLimit)
+		}
+		l.PidsLimit = requested.PidsLimit
+	}
+	if requested.Nofile != 0 {
+		if l.Nofile != 0 && requested.Nofile > l.Nofile {
+			return l, fmt.Errorf("nofile %d exceeds limit %d", requested.Nofile, l.Nofile)
+		}
+		l.Nofile = requested.Nofile
+	}
+	return l, nil
+}
+
+// slaveArgs returns the arguments for rkt-run-slave to apply the limits
+func (l *ResourceLimitsT) slaveArgs() []string {
+	var args []string
+	if l.PidsLimit != 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(l.PidsLimit))
+	}
+	if l.Nofile != 0 {
+		args = append(args, "--nofile", strconv.Itoa(l.Nofile))
+	}
+	return args
+}
+
+// validate reports any maximum less than the limit, at the key of the table
+func (m *ResourceMaximumsT) validate(ch *configCheckerT, table toml.Key, l *ResourceLimitsT) {
+	key := func(name string) toml.Key {
+		return append(append(toml.Key{}, table...), name)
+	}
+	if m.MaxCPU != 0 && m.MaxCPU < l.CPU {
+		ch.reportf(key("max-cpu"), "max-cpu %v less than cpu %v", m.MaxCPU, l.CPU)
+	}
+	if m.MaxMemory != 0 && m.MaxMemory < l.Memory {
+		ch.reportf(key("max-memory"), "max-memory %v less than memory %v", m.MaxMemory, l.Memory)
+	}
+}
+
+// validate reports any invalid limits, at the key of the table
+func (l *ResourceLimitsT) validate(ch *configCheckerT, table toml.Key, slave bool) {
+	key := func(name string) toml.Key {
+		return append(append(toml.Key{}, table...), name)
+	}
+	if l.PidsLimit < 0 {
+		ch.reportf(key("pids-limit"), "pids-limit cannot be negative")
+	}
+	if l.Nofile < 0 {
+		ch.reportf(key("nofile"), "nofile cannot be negative")
+	}
+	if l.PidsLimit != 0 && !slave {
+		ch.reportf(key("pids-limit"), "pids-limit requires exec-slave-dir")
+	}
+	if l.Nofile != 0 && !slave {
+		ch.reportf(key("nofile"), "nofile requires exec-slave-dir")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCPU(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected CPUT
+		format   string
+	}{
+		{"2", 2000, "2"},
+		{"1.5", 1500, "1500m"},
+		{"500m", 500, "500m"},
+		{"2.000000", 2000, "2"},
+	}
+	for _, test := range tests {
+		cpu, err := ParseCPU(test.s)
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if cpu != test.expected || cpu.String() != test.format {
+			t.Errorf("%s: parsed %d formatted %s, expected %d %s", test.s, cpu, cpu, test.expected, test.format)
+		}
+	}
+	for _, bad := range []string{"", "-1", "2x", "m"} {
+		if _, err := ParseCPU(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected MemoryT
+		format   string
+	}{
+		{"1024", 1024, "1Ki"},
+		{"512M", 512000000, "512M"},
+		{"4Gi", 4 << 30, "4Gi"},
+		{"1.5G", 1500000000, "1500M"},
+		{"100", 100, "100"},
+	}
+	for _, test := range tests {
+		memory, err := ParseMemory(test.s)
+		if err != nil {
+			t.Errorf("%s: %v", test.s, err)
+			continue
+		}
+		if memory != test.expected || memory.String() != test.format {
+			t.Errorf("%s: parsed %d formatted %s, expected %d %s", test.s, memory, memory, test.expected, test.format)
+		}
+	}
+	for _, bad := range []string{"", "-1G", "4GB", "Gi"} {
+		if _, err := ParseMemory(bad); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestResourceLimitsLower(t *testing.T) {
+	limits := ResourceLimitsT{CPU: 1000, Memory: 4 << 30, PidsLimit: 128, Nofile: 1024}
+	lowered, err := limits.request(&ResourceLimitsT{CPU: 500, PidsLimit: 64}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (ResourceLimitsT{CPU: 500, Memory: 4 << 30, PidsLimit: 64, Nofile: 1024}); lowered != expected {
+		t.Errorf("lowered %+v, expected %+v", lowered, expected)
+	}
+
+	for _, raised := range []ResourceLimitsT{{CPU: 2000}, {Memory: 8 << 30}, {PidsLimit: 256}, {Nofile: 4096}} {
+		_, err = limits.request(&raised, nil)
+		if err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+			t.Errorf("raise %+v: expected exceeds limit, got %v", raised, err)
+		}
+	}
+}
+
+func TestResourceLimitsRaise(t *testing.T) {
+	limits := ResourceLimitsT{CPU: 1000, Memory: 4 << 30}
+	maximums := []maximumT{
+		{"alias spades_", ResourceMaximumsT{MaxMemory: 256 << 30}},
+		{"your groups", ResourceMaximumsT{MaxCPU: 8000, MaxMemory: 64 << 30}},
+	}
+
+	tests := []struct {
+		requested ResourceLimitsT
+		expected  ResourceLimitsT
+		err       string
+	}{
+		{ResourceLimitsT{Memory: 64 << 30}, ResourceLimitsT{CPU: 1000, Memory: 64 << 30}, ""},
+		{ResourceLimitsT{CPU: 8000}, ResourceLimitsT{CPU: 8000, Memory: 4 << 30}, ""},
+		{ResourceLimitsT{Memory: 128 << 30}, limits, "memory 128Gi exceeds maximum 64Gi for your groups"},
+		{ResourceLimitsT{CPU: 16000}, limits, "cpus 16 exceeds maximum 8 for your groups"},
+	}
+	for _, test := range tests {
+		actual, err := limits.request(&test.requested, maximums)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("request %+v: error %v, expected %s", test.requested, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("request %+v: %v", test.requested, err)
+		} else if actual != test.expected {
+			t.Errorf("request %+v: %+v, expected %+v", test.requested, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// valid formats for --list-alias
+const TextFormat = "text"
+const JSONFormat = "json"
+const TSVFormat = "tsv"
+
+// AliasListing is an alias as listed by --list-alias in a structured
+// format.  Parent is the alias which defined this one by exec or version,
+// if any.
+type AliasListing struct {
+	Name        string            `json:"name"`
+	Parent      string            `json:"parent"`
+	Image       string            `json:"image"`
+	Exec        string            `json:"exec"`
+	Version     string            `json:"version"`
+	Description string            `json:"description"`
+	Homepage    string            `json:"homepage"`
+	Tags        []string          `json:"tags"`
+	Category    string            `json:"category"`
+	Fetched     bool              `json:"fetched"`
+	Environment map[string]string `json:"environment"`
+	Volumes     []string          `json:"volumes"`
+}
+
+func validateListFormat(format string) error {
+	switch format {
+	case "", TextFormat, JSONFormat, TSVFormat:
+		return nil
+	}
+	return fmt.Errorf("unknown format %s, expected one of %s, %s, %s", format, TextFormat, JSONFormat, TSVFormat)
+}
+
+// fetchedImages returns the set of images already fetched
+func (r *RunnerT) fetchedImages() (map[string]bool, error) {
+	images, err := r.runtime.Images()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %v", err)
+	}
+	fetched := make(map[string]bool)
+	for _, image := range images {
+		fetched[image.Name] = true
+	}
+	return fetched, nil
+}
+
+// onRequestVolumes returns the names of the volumes the user may request
+func (r *RunnerT) onRequestVolumes() ([]string, error) {
+	names := []string{}
+	for _, name := range r.config.volumeNames() {
+		vol := r.config.Volume[name]
+		if !vol.OnRequest {
+			continue
+		}
+		allowed, err := vol.allows(r.user)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// aliasKeys returns the names of all the aliases, in order
+func (r *RunnerT) aliasKeys() []string {
+	keys := make([]string, 0, len(r.aliases))
+	for key := range r.aliases {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// matchesSearch returns whether the alias matches the search term,
+// ignoring case, by name, exec basename, image, or description fields.
+func (r *RunnerT) matchesSearch(key, term string) bool {
+	alias := r.aliases[key]
+	imageAlias := r.config.Alias[alias.name]
+	fields := []string{
+		key,
+		alias.image,
+		imageAlias.Description,
+		imageAlias.Homepage,
+		imageAlias.Category,
+	}
+	if alias.exec != "" {
+		fields = append(fields, filepath.Base(alias.exec))
+	}
+	fields = append(fields, imageAlias.Tags...)
+
+	term = strings.ToLower(term)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchAliasKeys returns the names of the aliases matching term, in order
+func (r *RunnerT) searchAliasKeys(term string) []string {
+	var keys []string
+	for _, key := range r.aliasKeys() {
+		if r.matchesSearch(key, term) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// aliasListings returns the named aliases.  If the images can't be
+// listed, the aliases are listed as not fetched, with a warning.
+func (r *RunnerT) aliasListings(keys []string) ([]AliasListing, error) {
+	fetched, err := r.fetchedImages()
+	if err != nil {
+		WarnError(err)
+	}
+	volumes, err := r.onRequestVolumes()
+	if err != nil {
+		return nil, err
+	}
+
+	listings := make([]AliasListing, 0, len(keys))
+	for _, key := range keys {
+		alias := r.aliases[key]
+		var parent string
+		if alias.name != key {
+			parent = alias.name
+		}
+		environment := make(map[string]string)
+		for name, value := range r.fragments.Alias[alias.name].Environment {
+			environment[name] = value
+		}
+		imageAlias := r.config.Alias[alias.name]
+		tags := append([]string{}, imageAlias.Tags...)
+		listings = append(listings, AliasListing{
+			Name:        key,
+			Parent:      parent,
+			Image:       alias.image,
+			Exec:        alias.exec,
+			Version:     alias.version,
+			Description: imageAlias.Description,
+			Homepage:    imageAlias.Homepage,
+			Tags:        tags,
+			Category:    imageAlias.Category,
+			Fetched:     fetched[CanonicalImageName(alias.image)],
+			Environment: environment,
+			Volumes:     volumes,
+		})
+	}
+	return listings, nil
+}
+
+// tsvEscape escapes the characters which would break a TSV field
+func tsvEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n").Replace(s)
+}
+
+func printAliasListingsTSV(w io.Writer, listings []AliasListing) {
+	fmt.Fprintf(w, "name\tparent\timage\texec\tversion\tdescription\thomepage\ttags\tcategory\tfetched\tenvironment\tvolumes\n")
+	for _, l := range listings {
+		environment := make([]string, 0, len(l.Environment))
+		for _, name := range sortedKeys(l.Environment) {
+			environment = append(environment, fmt.Sprintf("%s=%s", name, l.Environment[name]))
+		}
+		fields := []string{
+			l.Name,
+			l.Parent,
+			l.Image,
+			l.Exec,
+			l.Version,
+			l.Description,
+			l.Homepage,
+			strings.Join(l.Tags, ","),
+			l.Category,
+			fmt.Sprintf("%t", l.Fetched),
+			strings.Join(environment, ","),
+			strings.Join(l.Volumes, ","),
+		}
+		for i := range fields {
+			fields[i] = tsvEscape(fields[i])
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(fields, "\t"))
+	}
+}
+
+// printAliasesWithDescription prints the named aliases, each followed
+// by its description, if any
+func (r *RunnerT) printAliasesWithDescription(w io.Writer, keys []string) {
+	for _, key := range keys {
+		alias := r.aliases[key]
+		fmt.Fprintf(w, "%s\n", formatAlias(key, alias))
+		description := r.config.Alias[alias.name].Description
+		if description != "" {
+			fmt.Fprintf(w, "    %s\n", description)
+		}
+	}
+}
+
+// listAliases writes the named aliases to w in the given format
+func (r *RunnerT) listAliases(w io.Writer, format string, keys []string) error {
+	if format == "" || format == TextFormat {
+		r.printAliases(w, keys)
+		return nil
+	}
+
+	listings, err := r.aliasListings(keys)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case JSONFormat:
+		b, err := json.MarshalIndent(listings, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", b)
+	case TSVFormat:
+		printAliasListingsTSV(w, listings)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testListConfig = testConfig + `description = "BusyBox utilities"
+category = "utilities"
+tags = ["shell", "coreutils"]
+
+[alias.busybox_.environment]
+PAGER = "less"
+
+[alias.samtools]
+image = "docker://biocontainers/samtools:1.3.1"
+homepage = "http://www.htslib.org"
+`
+
+func listAliases(t *testing.T, args ...string) string {
+	h := newHarness(t)
+	h.writeConfig(testListConfig)
+	h.script("image", "sha512-0123456789ab\tregistry-1.docker.io/biocontainers/samtools:1.3.1\n", 0)
+
+	cmd, stderr := h.rktRunCommand(args...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("rkt-run %s: %v\n%s", strings.Join(args, " "), err, stderr.String())
+	}
+	return stdout.String()
+}
+
+func TestListAliasJSON(t *testing.T) {
+	var actual []AliasListing
+	err := json.Unmarshal([]byte(listAliases(t, "--list-alias", "--format", JSONFormat)), &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []AliasListing{
+		{
+			Name:        "busybox_",
+			Image:       "example.com/tools/busybox:1.0",
+			Description: "BusyBox utilities",
+			Tags:        []string{"shell", "coreutils"},
+			Category:    "utilities",
+			Environment: map[string]string{"PAGER": "less"},
+			Volumes:     []string{"dataset"},
+		},
+		{
+			Name:        "grep",
+			Parent:      "busybox_",
+			Image:       "example.com/tools/busybox:1.0",
+			Exec:        "/bin/grep",
+			Description: "BusyBox utilities",
+			Tags:        []string{"shell", "coreutils"},
+			Category:    "utilities",
+			Environment: map[string]string{"PAGER": "less"},
+			Volumes:     []string{"dataset"},
+		},
+		{
+			Name:        "samtools",
+			Image:       "docker://biocontainers/samtools:1.3.1",
+			Homepage:    "http://www.htslib.org",
+			Tags:        []string{},
+			Fetched:     true,
+			Environment: map[string]string{},
+			Volumes:     []string{"dataset"},
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("listed aliases:\n%+v\nexpected:\n%+v", actual, expected)
+	}
+}
+
+func TestListAliasTSV(t *testing.T) {
+	actual := listAliases(t, "--list-alias", "--format", TSVFormat)
+	expected := "name\tparent\timage\texec\tversion\tdescription\thomepage\ttags\tcategory\tfetched\tenvironment\tvolumes\n" +
+		"busybox_\t\texample.com/tools/busybox:1.0\t\t\tBusyBox utilities\t\tshell,coreutils\tutilities\tfalse\tPAGER=less\tdataset\n" +
+		"grep\tbusybox_\texample.com/tools/busybox:1.0\t/bin/grep\t\tBusyBox utilities\t\tshell,coreutils\tutilities\tfalse\tPAGER=less\tdataset\n" +
+		"samtools\t\tdocker://biocontainers/samtools:1.3.1\t\t\t\thttp://www.htslib.org\t\t\ttrue\t\tdataset\n"
+	if actual != expected {
+		t.Errorf("listed aliases:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
+
+func TestListAliasImagesFailed(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testListConfig)
+	h.script("image", "", 1)
+
+	cmd, stderr := h.rktRunCommand("--list-alias", "--format", TSVFormat)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if err != nil {
+		t.Fatalf("rkt-run: %v\n%s", err, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "warning: failed to list images") {
+		t.Errorf("expected warning, got: %s", stderr.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("listed aliases:\n%s", stdout.String())
+	}
+	for _, line := range lines[1:] {
+		if fields := strings.Split(line, "\t"); fields[9] != "false" {
+			t.Errorf("expected not fetched: %s", line)
+		}
+	}
+}
+
+func TestSearchAlias(t *testing.T) {
+	for _, c := range []struct {
+		term, expected string
+	}{
+		{"GREP", "grep = -e /bin/grep example.com/tools/busybox:1.0\n    BusyBox utilities\n"},
+		{"coreutils", "busybox_ = example.com/tools/busybox:1.0\n    BusyBox utilities\n" +
+			"grep = -e /bin/grep example.com/tools/busybox:1.0\n    BusyBox utilities\n"},
+		{"htslib", "samtools = docker://biocontainers/samtools:1.3.1\n"},
+		{"biocontainers", "samtools = docker://biocontainers/samtools:1.3.1\n"},
+		{"nothing", ""},
+	} {
+		actual := listAliases(t, "--search", c.term)
+		if actual != c.expected {
+			t.Errorf("search %s:\n%s\nexpected:\n%s", c.term, actual, c.expected)
+		}
+	}
+}
+
+func TestSearchAliasJSON(t *testing.T) {
+	var actual []AliasListing
+	err := json.Unmarshal([]byte(listAliases(t, "--search", "utilities", "--format", JSONFormat)), &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual) != 2 || actual[0].Name != "busybox_" || actual[1].Name != "grep" {
+		t.Errorf("search utilities found %+v", actual)
+	}
+}
@@ -20,10 +20,14 @@ import (
 	"path/filepath"
 )
 
+// DefaultConfigFile is the site-wide config file
+const DefaultConfigFile = "/etc/rktrunner.toml"
+
 const slaveBinVolume = "rktrunner-bin"
 const slaveBinDir = "/usr/lib/rktrunner"
 
-const masterRoot = "/var/lib/rktrunner"
+// masterRoot is a variable only so that tests may run without root
+var masterRoot = "/var/lib/rktrunner"
 
 const slaveRunner = "rkt-run-slave"
 
@@ -37,6 +41,26 @@ func WorkerPodDir(uuid string) string {
 	return filepath.Join(masterRoot, fmt.Sprintf("%s%s", podPrefix, uuid))
 }
 
+// workerSessionPath is the lock file for a session slot in a worker pod
+func workerSessionPath(uuid string, slot int) string {
+	return filepath.Join(WorkerPodDir(uuid), fmt.Sprintf("session-%d", slot))
+}
+
+// workerFingerprintPath records how a worker pod was started
+func workerFingerprintPath(uuid string) string {
+	return filepath.Join(WorkerPodDir(uuid), "fingerprint")
+}
+
+// workerLastUsePath records when a session last started in a worker pod
+func workerLastUsePath(uuid string) string {
+	return filepath.Join(WorkerPodDir(uuid), "last-use")
+}
+
+// workerSessionEndPath records when a session last ended in a worker pod
+func workerSessionEndPath(uuid string) string {
+	return filepath.Join(WorkerPodDir(uuid), "session-end")
+}
+
 func envFilePath() string {
 	return filepath.Join(masterRunDir(), "env")
 }
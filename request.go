@@ -0,0 +1,105 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"os/user"
+)
+
+// RunRequest is what a user asks rkt-run to do, independently of how it
+// was asked.  The zero value, with an Image, runs that image in batch mode.
+type RunRequest struct {
+	// Image is an alias or image name, and Args its arguments
+	Image string
+	Args  []string
+
+	// Exec is the command to run instead of the image default
+	Exec string
+
+	// Volumes are the on-request volumes to mount
+	Volumes []string
+
+	// Setenvs are environment variables for the pod, as name=value
+	Setenvs []string
+
+	// Environ is the environment of the user, as name=value, from
+	// which the pod environment and config templates are derived
+	Environ []string
+
+	// Mode is BatchMode or InteractiveMode, default BatchMode
+	Mode string
+
+	// Config is an alternate config file, requiring root or DryRun
+	Config string
+
+	CheckConfig   bool
+	PrintEnv      bool
+	Prepare       bool
+	Verbose       bool
+	DryRun        bool
+	NoImagePrefix bool
+
+	// listing aliases, instead of running anything
+	ListAlias bool
+	Search    string
+	Format    string
+
+	// administration, instead of running anything
+	InstallShims string
+	Pin          bool
+
+	// limits, at most those configured
+	Timeout   string
+	Cpus      string
+	Memory    string
+	PidsLimit int
+	Nofile    int
+}
+
+// validate checks the request is consistent, and permitted for user u.
+func (req *RunRequest) validate(u *user.User) error {
+	switch req.Mode {
+	case "", BatchMode, InteractiveMode:
+	default:
+		return fmt.Errorf("unknown mode %s, expected %s or %s", req.Mode, BatchMode, InteractiveMode)
+	}
+
+	root := u.Uid == "0"
+	if req.Config != "" && !root && !req.DryRun {
+		return fmt.Errorf("alternate config file requires root or dry run")
+	}
+
+	if req.InstallShims != "" && !root && !req.DryRun {
+		return fmt.Errorf("install shims requires root or dry run")
+	}
+
+	if req.Pin && !root {
+		return fmt.Errorf("pin requires root")
+	}
+
+	if req.Format != "" && !req.ListAlias && req.Search == "" {
+		return fmt.Errorf("--format requires --list-alias or --search")
+	}
+	return validateListFormat(req.Format)
+}
+
+// mode returns the mode of the request, defaulting to batch
+func (req *RunRequest) mode() string {
+	if req.Mode == "" {
+		return BatchMode
+	}
+	return req.Mode
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewRunnerFromRequest(t *testing.T) {
+	h := newHarness(t)
+	h.setMasterRoot()
+	h.writeConfig(testConfig + "\n[alias.sh]\nimage = \"example.com/tools/busybox:1.0\"\n")
+
+	tests := []struct {
+		req      RunRequest
+		expected []string
+	}{
+		{
+			RunRequest{Image: "grep", Args: []string{"needle"}},
+			[]string{" example.com/tools/busybox:1.0 ", " --exec /bin/grep -- needle\n"},
+		},
+		{
+			RunRequest{Image: "sh", Mode: InteractiveMode, Volumes: []string{"dataset"}},
+			[]string{" --interactive ", " --volume dataset,kind=host,source=/dataset "},
+		},
+		{
+			RunRequest{Image: "busybox_", Exec: "/bin/ls", Args: []string{"-l"}},
+			[]string{" --exec /bin/ls -- -l\n"},
+		},
+	}
+	for _, test := range tests {
+		r, err := NewRunnerFromRequest(&test.req, h.user, h.config)
+		if err != nil {
+			t.Errorf("%+v: %v", test.req, err)
+			continue
+		}
+		var b bytes.Buffer
+		err = printCommands(r, &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(b.String(), expected) {
+				t.Errorf("%+v: %s\nmissing %s", test.req, b.String(), expected)
+			}
+		}
+	}
+}
+
+func TestNewRunnerFromRequestEnviron(t *testing.T) {
+	h := newHarness(t)
+	h.setMasterRoot()
+	h.writeConfig(testConfig)
+
+	req := RunRequest{Image: "grep", Environ: []string{"PATH=/opt/bin", "RKTRUNNER_TEST_VAR=found"}}
+	r, err := NewRunnerFromRequest(&req, h.user, h.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"PATH": "/opt/bin", "RKTRUNNER_TEST_VAR": "found"}
+	if !reflect.DeepEqual(r.hostEnviron, expected) {
+		t.Errorf("host environment %v, expected %v", r.hostEnviron, expected)
+	}
+}
+
+func TestNewRunnerFromInvalidRequest(t *testing.T) {
+	h := newHarness(t)
+	h.setMasterRoot()
+	h.writeConfig(testConfig)
+
+	// permission depends on the user making the request, not the process
+	h.setUid(0)
+	nonRoot := *h.user
+	nonRoot.Uid = "1000"
+
+	tests := []struct {
+		req      RunRequest
+		expected string
+	}{
+		{RunRequest{Image: "grep", Mode: "background"}, "unknown mode background"},
+		{RunRequest{Image: "grep", Config: h.config}, "alternate config file requires root"},
+		{RunRequest{Pin: true}, "pin requires root"},
+		{RunRequest{InstallShims: h.workDir}, "install shims requires root"},
+		{RunRequest{ListAlias: true, Format: "xml"}, "unknown format xml"},
+		{RunRequest{Image: "grep", Format: JSONFormat}, "--format requires --list-alias"},
+		{RunRequest{Image: "grep", Exec: "/bin/ls"}, "cannot specify executable with alias"},
+		{RunRequest{}, "missing image"},
+	}
+	for _, test := range tests {
+		_, err := NewRunnerFromRequest(&test.req, &nonRoot, h.config)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%+v: expected %s, got %v", test.req, test.expected, err)
+		}
+	}
+}
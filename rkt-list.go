@@ -17,7 +17,6 @@ package rktrunner
 import (
 	"bufio"
 	"fmt"
-	"os/exec"
 	"strings"
 )
 
@@ -34,9 +33,9 @@ func (p *VisitedPod) String() string {
 	return fmt.Sprintf("%s %s pod %s for %s", p.AppName, p.State, p.UUID, p.Image)
 }
 
-// VisitPods visits all pods, until the walker returns false.
-func VisitPods(walker func(*VisitedPod) bool) error {
-	cmd := exec.Command("rkt", "list", "--full", "--no-legend")
+// VisitPods visits all pods listed by rkt, until the walker returns false.
+func (r *rktRuntime) VisitPods(walker func(*VisitedPod) bool) error {
+	cmd := r.command("list", "--full", "--no-legend")
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return err
@@ -80,3 +79,19 @@ func VisitPods(walker func(*VisitedPod) bool) error {
 
 	return nil
 }
+
+// Images returns all images listed by rkt image list.
+func (r *rktRuntime) Images() ([]ImageInfo, error) {
+	out, err := r.command("image", "list", "--fields=id,name", "--full", "--no-legend").Output()
+	if err != nil {
+		return nil, err
+	}
+	var images []ImageInfo
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			images = append(images, ImageInfo{ID: fields[0], Name: fields[1]})
+		}
+	}
+	return images, nil
+}
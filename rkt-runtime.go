@@ -0,0 +1,206 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/appc/spec/schema"
+)
+
+const RktRuntime = "rkt"
+
+type rktRuntime struct {
+	rkt string
+}
+
+// NewRktRuntime returns the rkt backend, using the given rkt program,
+// which is looked up in the path if it is not absolute.
+func NewRktRuntime(rkt string) Runtime {
+	return &rktRuntime{rkt: rkt}
+}
+
+func newRktRuntimeFromConfig(c *configT) (Runtime, error) {
+	if c.Rkt == "" {
+		return nil, fmt.Errorf("missing rkt")
+	}
+	return NewRktRuntime(c.Rkt), nil
+}
+
+func (r *rktRuntime) Name() string {
+	return RktRuntime
+}
+
+func (r *rktRuntime) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(r.rkt, args...)
+	cmd.Args[0] = "rkt"
+	return cmd
+}
+
+func (r *rktRuntime) FetchCommand(spec *FetchSpec) *CommandT {
+	c := NewCommand(r.rkt)
+	c.AppendArgs(spec.GeneralOptions...)
+	c.AppendArgs("fetch")
+	c.AppendArgs(spec.FetchOptions...)
+	c.AppendArgs(spec.Image)
+	return c
+}
+
+func (r *rktRuntime) RunCommand(spec *RunSpec) *CommandT {
+	c := NewCommand(r.rkt)
+	c.AppendArgs(spec.GeneralOptions...)
+	c.AppendArgs("run")
+
+	c.AppendArgs("--uuid-file-save", spec.UUIDFile)
+	c.AppendArgs("--set-env-file", spec.EnvFile)
+	c.AppendArgs(spec.RunOptions...)
+
+	for _, vol := range spec.Volumes {
+		if vol.Volume != "" {
+			c.AppendArgs("--volume", fmt.Sprintf("%s,%s", vol.Name, vol.Volume))
+		}
+	}
+	c.AppendArgs(spec.Image)
+
+	if spec.AppName != "" {
+		c.AppendArgs("--name", spec.AppName)
+	}
+
+	for _, vol := range spec.Volumes {
+		if vol.Mount != "" {
+			c.AppendArgs("--mount", fmt.Sprintf("volume=%s,%s", vol.Name, vol.Mount))
+		}
+	}
+
+	// isolators
+	if spec.Limits.CPU != 0 {
+		c.AppendArgs(fmt.Sprintf("--cpu=%v", spec.Limits.CPU))
+	}
+	if spec.Limits.Memory != 0 {
+		c.AppendArgs(fmt.Sprintf("--memory=%v", spec.Limits.Memory))
+	}
+	c.AppendArgs(spec.ImageOptions...)
+
+	if spec.Exec != "" {
+		c.AppendArgs("--exec", spec.Exec, "--")
+	}
+	c.AppendArgs(spec.Args...)
+	return c
+}
+
+func (r *rktRuntime) EnterCommand(spec *EnterSpec) *CommandT {
+	c := NewCommand(r.rkt)
+	c.AppendArgs("enter", spec.UUID)
+	c.AppendArgs(spec.Args...)
+	return c
+}
+
+// Status returns the state reported by rkt status.
+func (r *rktRuntime) Status(uuid string) (string, error) {
+	cmd := r.command("status", uuid)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return "", fmt.Errorf("%s status %s failed to start: %v", r.rkt, uuid, err)
+	}
+
+	var state string
+	foundState := false
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "=", 2)
+		if !foundState && len(fields) == 2 && fields[0] == "state" {
+			foundState = true
+			state = fields[1]
+		}
+	}
+	err = scanner.Err()
+	errWait := cmd.Wait()
+	if err != nil {
+		return "", err
+	}
+	if errWait != nil {
+		return "", errWait
+	}
+	if !foundState {
+		return "", fmt.Errorf("rkt status %s failed to list state", uuid)
+	}
+	return state, nil
+}
+
+func (r *rktRuntime) Stop(uuid string) error {
+	return r.command("stop", uuid).Run()
+}
+
+func (r *rktRuntime) Pod(uuid string) (*PodInfo, error) {
+	pm, err := r.catManifest(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	pod := &PodInfo{UUID: uuid}
+	for _, ra := range pm.Apps {
+		app := AppInfo{
+			Name:   ra.Name.String(),
+			Rootfs: r.appRootfs(uuid, ra.Name.String()),
+		}
+		if ra.Image.Name != nil {
+			app.Image = ra.Image.Name.String()
+		}
+		if ra.App != nil {
+			app.User = ra.App.User
+		}
+		pod.Apps = append(pod.Apps, app)
+	}
+	return pod, nil
+}
+
+func (r *rktRuntime) catManifest(uuid string) (*schema.PodManifest, error) {
+	cmd := r.command("cat-manifest", uuid)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := json.NewDecoder(stdout)
+	var pm schema.PodManifest
+	err = manifest.Decode(&pm)
+	errWait := cmd.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if errWait != nil {
+		return nil, errWait
+	}
+	return &pm, nil
+}
+
+// appRootfs returns the host path of the root filesystem of the named
+// app within the pod, in the stage1 layout
+func (r *rktRuntime) appRootfs(uuid, appName string) string {
+	return fmt.Sprintf("/var/lib/rkt/pods/run/%s/stage1/rootfs/opt/stage2/%s/rootfs", uuid, appName)
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRktPod(t *testing.T) {
+	h := newHarness(t)
+	h.script("cat-manifest", podManifest("1001"), 0)
+
+	pod, err := NewRktRuntime(h.rkt).Pod(fakeUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &PodInfo{
+		UUID: fakeUUID,
+		Apps: []AppInfo{{
+			Name:   "worker",
+			Image:  "example.com/tools/busybox",
+			User:   "1001",
+			Rootfs: "/var/lib/rkt/pods/run/" + fakeUUID + "/stage1/rootfs/opt/stage2/worker/rootfs",
+		}},
+	}
+	if !reflect.DeepEqual(pod, expected) {
+		t.Errorf("pod %+v, expected %+v", pod, expected)
+	}
+}
@@ -19,139 +19,218 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/droundy/goopt"
 )
 
 var ErrNotRoot = errors.New("must run as root")
 
-type optionsT struct {
-	config        *string
-	exec          *string
-	volumes       *[]string
-	setenvs       *[]string
-	printEnv      *bool
-	interactive   *bool
-	prepare       *bool
-	verbose       *bool
-	dryRun        *bool
-	listAlias     *bool
-	noImagePrefix *bool
-}
+// real and effective uid, overridden by tests which run unprivileged
+var getuid = syscall.Getuid
+var geteuid = syscall.Geteuid
 
-type argsT struct {
-	options optionsT
-	image   string
-	cmdArgs []string
-}
+// real uid with which rkt-run was started, since rkt-run sets its real
+// uid to the effective uid before Execute
+var startUid = syscall.Getuid()
+
+// overridden by tests for a known user identity
+var currentUser = user.Current
 
 type aliasT struct {
 	name                 string
 	image                string
 	exec                 string
+	version              string
 	hostTimezone         bool
 	environmentUpdate    []string
 	environmentBlacklist map[string]bool
+	timeout              time.Duration
+	limits               *ResourceLimitsT
+	maximums             *ResourceMaximumsT
+	access               *AccessT
 }
 
 type RunnerT struct {
+	startTime        time.Time
+	user             *user.User
+	configFile       string
 	config           configT
+	runtime          Runtime
 	hostEnviron      map[string]string
 	podEnviron       map[string]string
 	aliases          map[string]aliasT
 	requestedVolumes map[string]bool
+	podUUID          string
+	timeout          time.Duration
+	limits           ResourceLimitsT
+	activeMutex      sync.Mutex
+	active           *CommandT
 	fragments        fragmentsT
-	args             argsT
+	request          RunRequest
+	mode             string
 	alias            *aliasT
 	image            string
+	imageID          string // verified against the pinned digest, if any
 	exec             string
 	fetchCommand     *CommandT
 	runCommand       *CommandT
 	enterCommand     *CommandT
 	worker           *Worker
+
+	pendingSetup bool // setup deferred to Execute
+	configRead   bool // config read and validated by setup
+
+	// the reason the request was refused, if it was
+	denied    error
+	auditOnce sync.Once
 }
 
+// NewRunner returns a runner for the rkt-run command line, run by the
+// current user.  The request is validated by Execute, so that a bad
+// request is audited like any other.
 func NewRunner(configFile string) (*RunnerT, error) {
-	var r RunnerT
-
-	err := r.parseArgs()
+	req, err := parseArgs()
 	if err != nil {
 		return nil, fmt.Errorf("bad usage: %v", err)
 	}
 
-	if *r.args.options.config != "" {
-		configFile = *r.args.options.config
+	u, err := currentUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current user: %v", err)
 	}
-	err = GetConfig(configFile, &r.config)
+	req.Environ = os.Environ()
+
+	r := newRunner(req, u, configFile)
+	r.pendingSetup = true
+	return r, nil
+}
+
+// NewRunnerFromRequest returns a runner for the request made by user u,
+// configured by configFile, unless the request has an alternate config.
+func NewRunnerFromRequest(req *RunRequest, u *user.User, configFile string) (*RunnerT, error) {
+	r := newRunner(req, u, configFile)
+	err := r.setup()
 	if err != nil {
-		return nil, fmt.Errorf("configuration error: %v", err)
+		return nil, err
 	}
+	return r, nil
+}
 
-	if *r.args.options.prepare && !r.config.WorkerPods {
-		return nil, fmt.Errorf("bad usage: prepare requires site-wide worker pods")
+func newRunner(req *RunRequest, u *user.User, configFile string) *RunnerT {
+	return &RunnerT{
+		startTime:   time.Now(),
+		request:     *req,
+		user:        u,
+		configFile:  configFile,
+		hostEnviron: ParseEnviron(req.Environ),
 	}
+}
 
-	err = r.validateRequestedVolumes()
+// setup validates the request, and initializes the runner for it.
+func (r *RunnerT) setup() error {
+	err := r.request.validate(r.user)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("bad usage: %v", err)
 	}
 
-	r.hostEnviron = ParseEnviron(os.Environ())
+	if r.request.Config != "" {
+		r.configFile = r.request.Config
+	}
 
-	err = r.registerAliases(os.Stderr, true)
+	if r.request.CheckConfig {
+		// config is read by Execute(), so all problems may be reported
+		return nil
+	}
+
+	return r.initialize()
+}
+
+// prepare completes any setup deferred by NewRunner
+func (r *RunnerT) prepare() error {
+	if !r.pendingSetup {
+		return nil
+	}
+	r.pendingSetup = false
+	return r.setup()
+}
+
+// initialize reads the config file, and prepares to run according to
+// the request.
+func (r *RunnerT) initialize() error {
+	err := GetConfig(r.configFile, &r.config)
 	if err != nil {
-		return nil, fmt.Errorf("configuration error: %v", err)
+		return fmt.Errorf("configuration error: %v", err)
 	}
+	r.configRead = true
 
-	u, err := user.Current()
+	r.runtime, err = NewRuntime(&r.config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current user: %v", err)
+		return fmt.Errorf("configuration error: %v", err)
+	}
+
+	if r.request.Prepare && !r.config.WorkerPods {
+		return fmt.Errorf("bad usage: prepare requires site-wide worker pods")
 	}
 
-	err = GetFragments(&r.config, r.templateVariables(u), &r.fragments)
+	err = r.validateRequestedVolumes()
 	if err != nil {
-		return nil, fmt.Errorf("configuration error: %v", err)
+		return err
 	}
 
-	var mode string
-	if *r.args.options.interactive {
-		mode = InteractiveMode
-	} else {
-		mode = BatchMode
+	err = r.registerAliases(os.Stderr, true)
+	if err != nil {
+		return fmt.Errorf("configuration error: %v", err)
+	}
+
+	err = GetFragments(&r.config, r.templateVariables(r.user), &r.fragments)
+	if err != nil {
+		return fmt.Errorf("configuration error: %v", err)
 	}
 
+	r.mode = r.request.mode()
+
 	// different functionality depending on options, see Execute()
 	switch {
-	case *r.args.options.listAlias:
+	case r.request.ListAlias, r.request.Search != "", r.request.InstallShims != "", r.request.Pin:
 		// do nothing for now
 	default:
 		err = r.validateCmdArgs()
 		if err == nil {
 			err = r.resolveImage()
 		}
+		if err == nil {
+			err = r.resolveTimeout()
+		}
+		if err == nil {
+			err = r.resolveLimits()
+		}
 		if err == nil && r.config.WorkerPods {
-			r.worker, err = NewWorker(u, r.image, r.config.Rkt, *r.args.options.verbose)
+			r.worker, err = NewWorker(r.user, r.image, r.workerFingerprint(), r.runtime, r.config.WorkerMaxSessions, r.request.Verbose)
 		}
 		// separate fetch is not working reliably, so hide it
 		_, separateFetch := os.LookupEnv("RKTRUNNER_SEPARATE_FETCH")
 		if err == nil && separateFetch {
-			err = r.buildFetchCommand(mode)
+			err = r.buildFetchCommand(r.mode)
 		}
 		if err == nil && (r.worker == nil || !r.worker.FoundPod()) {
-			err = r.buildRunCommand(mode)
+			err = r.buildRunCommand(r.mode)
 		}
 		if err != nil {
-			return nil, fmt.Errorf("bad usage: %v", err)
+			return fmt.Errorf("bad usage: %v", err)
 		}
 	}
 
-	return &r, nil
+	return nil
 }
 
 func (r *RunnerT) aliasName() string {
@@ -191,7 +270,7 @@ func (r *RunnerT) environmentBlacklisted(name string) bool {
 func (r *RunnerT) validateRequestedVolumes() error {
 	// check volumes passed on command line are in config file as on-request
 	r.requestedVolumes = make(map[string]bool)
-	for _, requested := range *r.args.options.volumes {
+	for _, requested := range r.request.Volumes {
 		valid := true
 		vol, ok := r.config.Volume[requested]
 		if ok {
@@ -204,6 +283,13 @@ func (r *RunnerT) validateRequestedVolumes() error {
 		if !valid {
 			return fmt.Errorf("invalid volume: %s", requested)
 		}
+		allowed, err := vol.allows(r.user)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return r.deny(fmt.Errorf("volume %s not permitted for user %s", requested, r.user.Username))
+		}
 		r.requestedVolumes[requested] = true
 	}
 	return nil
@@ -211,35 +297,57 @@ func (r *RunnerT) validateRequestedVolumes() error {
 
 // runWithSlave returns whether we need the slave on running a pod
 func (r *RunnerT) runWithSlave() bool {
-	return r.config.PreserveCwd || r.config.UsePath || r.config.WorkerPods
+	return r.config.PreserveCwd || r.config.UsePath || r.config.WorkerPods || r.limits.needsSlave()
 }
 
 // enterWithSlave returns whether we need the slave on entering a pod
 func (r *RunnerT) enterWithSlave() bool {
-	return r.config.PreserveCwd || r.config.UsePath || (r.alias != nil && r.alias.environmentUpdate != nil)
+	return r.config.PreserveCwd || r.config.UsePath || (r.alias != nil && r.alias.environmentUpdate != nil) || r.limits.needsSlave()
 }
 
+// autoPrefix substitutes the longest matching prefix, if any
 func (r *RunnerT) autoPrefix(image string) string {
-	for key, val := range r.config.AutoImagePrefix {
+	keys := make([]string, 0, len(r.config.AutoImagePrefix))
+	for key := range r.config.AutoImagePrefix {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
 		if strings.HasPrefix(image, key) {
-			return strings.Replace(image, key, val, 1)
+			return strings.Replace(image, key, r.config.AutoImagePrefix[key], 1)
 		}
 	}
 	return image
 }
 
-func (r *RunnerT) parseArgs() error {
-	r.args.options.config = goopt.String([]string{"--config"}, "", "alternative config file, requires root or --dry-run")
-	r.args.options.exec = goopt.String([]string{"-e", "--exec"}, "", "command to run instead of image default")
-	r.args.options.volumes = goopt.Strings([]string{"--volume"}, "", "activate pre-defined volume")
-	r.args.options.setenvs = goopt.Strings([]string{"--set-env"}, "", "environment variable")
-	r.args.options.printEnv = goopt.Flag([]string{"--print-env"}, []string{}, "print environment variables passed into container", "")
-	r.args.options.interactive = goopt.Flag([]string{"-i", "--interactive"}, []string{}, "run image interactively", "")
-	r.args.options.prepare = goopt.Flag([]string{"--prepare"}, []string{}, "prepare worker pod, but don't execute anything", "")
-	r.args.options.verbose = goopt.Flag([]string{"-v", "--verbose"}, []string{}, "show full rkt run command", "")
-	r.args.options.dryRun = goopt.Flag([]string{"--dry-run"}, []string{}, "don't execute anything", "")
-	r.args.options.listAlias = goopt.Flag([]string{"-l", "--list-alias"}, []string{}, "list image aliases", "")
-	r.args.options.noImagePrefix = goopt.Flag([]string{"-n", "--no-image-prefix"}, []string{}, "disable auto image prefix", "")
+// parseArgs parses the rkt-run command line into a request
+func parseArgs() (*RunRequest, error) {
+	config := goopt.String([]string{"--config"}, "", "alternative config file, requires root or --dry-run")
+	checkConfig := goopt.Flag([]string{"--check-config"}, []string{}, "check config file, reporting all problems", "")
+	exec := goopt.String([]string{"-e", "--exec"}, "", "command to run instead of image default")
+	volumes := goopt.Strings([]string{"--volume"}, "", "activate pre-defined volume")
+	setenvs := goopt.Strings([]string{"--set-env"}, "", "environment variable")
+	printEnv := goopt.Flag([]string{"--print-env"}, []string{}, "print environment variables passed into container", "")
+	interactive := goopt.Flag([]string{"-i", "--interactive"}, []string{}, "run image interactively", "")
+	prepare := goopt.Flag([]string{"--prepare"}, []string{}, "prepare worker pod, but don't execute anything", "")
+	verbose := goopt.Flag([]string{"-v", "--verbose"}, []string{}, "show full rkt run command", "")
+	dryRun := goopt.Flag([]string{"--dry-run"}, []string{}, "don't execute anything", "")
+	listAlias := goopt.Flag([]string{"-l", "--list-alias"}, []string{}, "list image aliases", "")
+	search := goopt.String([]string{"--search"}, "", "list aliases matching term, in name, exec, image, description, homepage, tags or category")
+	installShims := goopt.String([]string{"--install-shims"}, "", "maintain links to rkt-run-helper in directory for all exec aliases, requires root or --dry-run")
+	pin := goopt.Flag([]string{"--pin"}, []string{}, "fetch images for all aliases, and write their digests to the lock file, requires root", "")
+	format := goopt.String([]string{"--format"}, "", "list aliases as text, json or tsv")
+	noImagePrefix := goopt.Flag([]string{"-n", "--no-image-prefix"}, []string{}, "disable auto image prefix", "")
+	timeout := goopt.String([]string{"--timeout"}, "", "terminate command after duration, e.g. 30m, at most alias timeout")
+	cpus := goopt.String([]string{"--cpus"}, "", "limit cpu, e.g. 2 or 500m, at most configured maximum")
+	memory := goopt.String([]string{"--memory"}, "", "limit memory, e.g. 4G, at most configured maximum")
+	pidsLimit := goopt.Int([]string{"--pids-limit"}, 0, "limit number of processes, at most configured limit")
+	nofile := goopt.Int([]string{"--nofile"}, 0, "limit number of open files, at most configured limit")
 	goopt.RequireOrder = true
 	goopt.Author = "Simon Guest <simon.guest@tesujimath.org>"
 	goopt.Description = func() string {
@@ -254,21 +362,42 @@ $ rkt-run <options> <image> [<args>]
 	goopt.Parse(nil)
 	args := goopt.Args
 
-	// validate options
-	if *r.args.options.config != "" && syscall.Getuid() != 0 && !*r.args.options.dryRun {
-		return fmt.Errorf("alternate config file requires root or dry run")
+	req := &RunRequest{
+		Config:        *config,
+		CheckConfig:   *checkConfig,
+		Exec:          *exec,
+		Volumes:       *volumes,
+		Setenvs:       *setenvs,
+		PrintEnv:      *printEnv,
+		Prepare:       *prepare,
+		Verbose:       *verbose,
+		DryRun:        *dryRun,
+		ListAlias:     *listAlias,
+		Search:        *search,
+		InstallShims:  *installShims,
+		Pin:           *pin,
+		Format:        *format,
+		NoImagePrefix: *noImagePrefix,
+		Timeout:       *timeout,
+		Cpus:          *cpus,
+		Memory:        *memory,
+		PidsLimit:     *pidsLimit,
+		Nofile:        *nofile,
+	}
+	if *interactive {
+		req.Mode = InteractiveMode
 	}
 
 	// image
 	if len(args) > 0 && args[0] != "" {
-		r.args.image = args[0]
+		req.Image = args[0]
 	}
 
 	if len(args) > 1 {
-		r.args.cmdArgs = args[1:]
+		req.Args = args[1:]
 	}
 
-	return nil
+	return req, nil
 }
 
 func formatAlias(key string, val aliasT) string {
@@ -285,7 +414,13 @@ func (r *RunnerT) registerAlias(w io.Writer, warn bool, key string, val *aliasT)
 	var err error
 	dupVal, isDup := r.aliases[key]
 	if isDup {
-		err = fmt.Errorf("duplicate alias: %s", key)
+		dupSource := r.config.Source(AliasTable, dupVal.name)
+		source := r.config.Source(AliasTable, val.name)
+		if dupSource != source {
+			err = fmt.Errorf("duplicate alias: %s, from alias %s in %s and alias %s in %s", key, dupVal.name, dupSource, val.name, source)
+		} else {
+			err = fmt.Errorf("duplicate alias: %s", key)
+		}
 		if warn {
 			fmt.Fprintf(w, "%s\n", formatAlias(key, dupVal))
 			fmt.Fprintf(w, "%s\n", formatAlias(key, *val))
@@ -297,6 +432,18 @@ func (r *RunnerT) registerAlias(w io.Writer, warn bool, key string, val *aliasT)
 }
 
 func (r *RunnerT) registerAliases(w io.Writer, warn bool) error {
+	var anyErr error
+	r.registerAllAliases(w, warn, func(key toml.Key, err error) {
+		if anyErr == nil {
+			anyErr = err
+		}
+	})
+	return anyErr
+}
+
+// registerAllAliases registers all the aliases, reporting each
+// problem against its config key, and carrying on regardless.
+func (r *RunnerT) registerAllAliases(w io.Writer, warn bool, report func(toml.Key, error)) {
 	strings2map := func(ss []string) map[string]bool {
 		m := make(map[string]bool)
 		for _, s := range ss {
@@ -304,50 +451,67 @@ func (r *RunnerT) registerAliases(w io.Writer, warn bool) error {
 		}
 		return m
 	}
-	var anyErr error
 	r.aliases = make(map[string]aliasT)
-	for imageKey, imageAlias := range r.config.Alias {
+	for _, imageKey := range sortedKeys(r.config.Alias) {
+		imageAlias := r.config.Alias[imageKey]
 		blacklist := strings2map(imageAlias.EnvironmentBlacklist)
 		for _, name := range imageAlias.EnvironmentUpdate {
 			if blacklist[name] {
-				return fmt.Errorf("alias %s cannot have environment-update and blacklist for %s", imageKey, name)
+				report(toml.Key{AliasTable, imageKey, "environment-update"}, fmt.Errorf("alias %s cannot have environment-update and blacklist for %s", imageKey, name))
 			}
 		}
 
-		err := r.registerAlias(w, warn, imageKey, &aliasT{
-			name:                 imageKey,
-			image:                imageAlias.Image,
-			hostTimezone:         imageAlias.HostTimezone,
-			environmentUpdate:    imageAlias.EnvironmentUpdate,
-			environmentBlacklist: blacklist,
-		})
-		if err != nil && anyErr == nil {
-			anyErr = err
-		}
-		for _, exec := range imageAlias.Exec {
-			err = r.registerAlias(w, warn, filepath.Base(exec), &aliasT{
+		newAlias := func(image, exec, version string) *aliasT {
+			return &aliasT{
 				name:                 imageKey,
-				image:                imageAlias.Image,
+				image:                image,
 				exec:                 exec,
+				version:              version,
 				hostTimezone:         imageAlias.HostTimezone,
 				environmentUpdate:    imageAlias.EnvironmentUpdate,
 				environmentBlacklist: blacklist,
-			})
-			if err != nil && anyErr == nil {
-				anyErr = err
+				timeout:              imageAlias.Timeout.Duration,
+				limits:               &imageAlias.ResourceLimitsT,
+				maximums:             &imageAlias.ResourceMaximumsT,
+				access:               &imageAlias.AccessT,
+			}
+		}
+
+		// unversioned aliases follow the default version, if any
+		image := imageAlias.defaultImage()
+		err := r.registerAlias(w, warn, imageKey, newAlias(image, "", imageAlias.DefaultVersion))
+		if err != nil {
+			report(toml.Key{AliasTable, imageKey}, err)
+		}
+		for _, exec := range imageAlias.Exec {
+			err = r.registerAlias(w, warn, filepath.Base(exec), newAlias(image, exec, imageAlias.DefaultVersion))
+			if err != nil {
+				report(toml.Key{AliasTable, imageKey, "exec"}, err)
+			}
+		}
+
+		for _, version := range sortedKeys(imageAlias.Versions) {
+			image := imageAlias.Versions[version]
+			err = r.registerAlias(w, warn, versionedAlias(imageKey, version), newAlias(image, "", version))
+			if err != nil {
+				report(toml.Key{AliasTable, imageKey, "versions", version}, err)
+			}
+			for _, exec := range imageAlias.Exec {
+				err = r.registerAlias(w, warn, versionedAlias(filepath.Base(exec), version), newAlias(image, exec, version))
+				if err != nil {
+					report(toml.Key{AliasTable, imageKey, "versions", version}, err)
+				}
 			}
 		}
 	}
-	return anyErr
 }
 
-func (r *RunnerT) printAliases(w io.Writer) {
-	// get keys in order
-	keys := make([]string, 0, len(r.aliases))
-	for key := range r.aliases {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
+// versionedAlias returns the alias for a specific version, e.g. samtools@1.4.1
+func versionedAlias(alias, version string) string {
+	return fmt.Sprintf("%s%c%s", alias, VersionSeparator, version)
+}
+
+func (r *RunnerT) printAliases(w io.Writer, keys []string) {
 	for _, key := range keys {
 		fmt.Fprintf(w, "%s\n", formatAlias(key, r.aliases[key]))
 	}
@@ -370,7 +534,7 @@ func (r *RunnerT) templateVariables(u *user.User) map[string]string {
 func (r *RunnerT) printEnvironment(w io.Writer) {
 	PrintEnviron(w, r.podEnviron)
 
-	for _, setenv := range *r.args.options.setenvs {
+	for _, setenv := range r.request.Setenvs {
 		fmt.Fprintf(w, "%s\n", setenv)
 	}
 }
@@ -384,46 +548,228 @@ func (r *RunnerT) createEnvFile(path string) error {
 
 	PrintEnviron(f, r.podEnviron)
 
-	for _, setenv := range *r.args.options.setenvs {
+	for _, setenv := range r.request.Setenvs {
 		fmt.Fprintf(f, "%s\n", setenv)
 	}
 	return nil
 }
 
+// aliasAccess returns the access control for an alias, being its
+// own, or else the default.
+func (r *RunnerT) aliasAccess(access *AccessT) *AccessT {
+	if access == nil || access.isEmpty() {
+		return &r.config.DefaultAccess
+	}
+	return access
+}
+
+// checkAccess checks whether the user is allowed by access, returning
+// denied if not.
+func (r *RunnerT) checkAccess(access *AccessT, denied error) error {
+	allowed, err := access.allows(r.user)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return r.deny(denied)
+	}
+	return nil
+}
+
+// checkAliasAccess checks whether the user may use the alias,
+// according to its own access control, or else the default.
+func (r *RunnerT) checkAliasAccess(alias *aliasT) error {
+	return r.checkAccess(r.aliasAccess(alias.access), fmt.Errorf("alias %s not permitted for user %s", alias.name, r.user.Username))
+}
+
+// checkImageAccess checks whether the user may run an image given by
+// name rather than by alias.  Every alias for the same repository, with
+// any tag or digest, must allow the user, so that access control cannot
+// be bypassed by naming the image directly.  An image which is not
+// aliased is subject to the default access.  An image ID could be any
+// image at all, so may be run only by alias.
+func (r *RunnerT) checkImageAccess() error {
+	if isImageID(r.request.Image) || isImageID(r.image) {
+		return r.deny(fmt.Errorf("image ID %s not permitted for user %s, only by alias", r.image, r.user.Username))
+	}
+	repository := repositoryName(r.image)
+	aliased := false
+	for _, aliasKey := range sortedKeys(r.config.Alias) {
+		imageAlias := r.config.Alias[aliasKey]
+		images := []string{imageAlias.Image}
+		for _, image := range imageAlias.Versions {
+			images = append(images, image)
+		}
+		for _, image := range images {
+			if image == "" || repositoryName(image) != repository {
+				continue
+			}
+			aliased = true
+			err := r.checkAccess(r.aliasAccess(&imageAlias.AccessT), fmt.Errorf("image %s not permitted for user %s by alias %s", r.image, r.user.Username, aliasKey))
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	if !aliased {
+		return r.checkAccess(&r.config.DefaultAccess, fmt.Errorf("image %s not permitted for user %s", r.image, r.user.Username))
+	}
+	return nil
+}
+
+// resolveTimeout determines the timeout, from the alias and/or
+// the option, which may only reduce that of the alias.
+func (r *RunnerT) resolveTimeout() error {
+	if r.alias != nil {
+		r.timeout = r.alias.timeout
+	}
+	if r.request.Timeout != "" {
+		timeout, err := time.ParseDuration(r.request.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %v", err)
+		}
+		if timeout <= 0 {
+			return fmt.Errorf("invalid timeout: %v", timeout)
+		}
+		if r.timeout != 0 && timeout > r.timeout {
+			return fmt.Errorf("timeout %v exceeds maximum %v for %s", timeout, r.timeout, r.alias.name)
+		}
+		r.timeout = timeout
+	}
+	return nil
+}
+
+// resourceMaximums returns the maximums which apply to raising limits,
+// from the alias or global config, and the most generous of the
+// user's groups.
+func (r *RunnerT) resourceMaximums() ([]maximumT, error) {
+	var maximums []maximumT
+
+	global := r.config.ResourceMaximumsT
+	if r.alias != nil {
+		aliasMaximums := global.override(r.alias.maximums)
+		maximums = append(maximums, maximumT{fmt.Sprintf("alias %s", r.alias.name), aliasMaximums})
+	} else {
+		maximums = append(maximums, maximumT{"images", global})
+	}
+
+	if len(r.config.GroupLimits) > 0 {
+		groups, err := userGroups(r.user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get groups for %s: %v", r.user.Username, err)
+		}
+		var groupMaximums ResourceMaximumsT
+		isGroupMember := false
+		for _, group := range groups {
+			m, ok := r.config.GroupLimits[group]
+			if ok {
+				groupMaximums = groupMaximums.widen(&m)
+				isGroupMember = true
+			}
+		}
+		if isGroupMember {
+			maximums = append(maximums, maximumT{"your groups", groupMaximums})
+		}
+	}
+	return maximums, nil
+}
+
+// resolveLimits determines the resource limits, from the config,
+// changed by any requested in the options.
+func (r *RunnerT) resolveLimits() error {
+	r.limits = r.config.ResourceLimitsT
+	if r.alias != nil {
+		r.limits = r.limits.override(r.alias.limits)
+	}
+
+	var requested ResourceLimitsT
+	var err error
+	if r.request.Cpus != "" {
+		requested.CPU, err = ParseCPU(r.request.Cpus)
+		if err != nil {
+			return err
+		}
+	}
+	if r.request.Memory != "" {
+		requested.Memory, err = ParseMemory(r.request.Memory)
+		if err != nil {
+			return err
+		}
+	}
+	if r.request.PidsLimit < 0 || r.request.Nofile < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	requested.PidsLimit = r.request.PidsLimit
+	requested.Nofile = r.request.Nofile
+
+	maximums, err := r.resourceMaximums()
+	if err != nil {
+		return err
+	}
+	r.limits, err = r.limits.request(&requested, maximums)
+	if err == nil && r.limits.needsSlave() && r.config.ExecSlaveDir == "" {
+		err = fmt.Errorf("pids-limit/nofile requires exec-slave-dir")
+	}
+	return err
+}
+
 func (r *RunnerT) resolveImage() error {
-	if r.args.image == "" {
+	if r.request.Image == "" {
 		return fmt.Errorf("missing image")
-	} else if r.args.image[0] == '-' {
+	} else if r.request.Image[0] == '-' {
 		return fmt.Errorf("image cannot start with -")
 	}
 
-	alias, ok := r.aliases[r.args.image]
+	alias, ok := r.aliases[r.request.Image]
+	if !ok {
+		sep := strings.LastIndexByte(r.request.Image, VersionSeparator)
+		if sep > 0 {
+			unversioned, isAlias := r.aliases[r.request.Image[:sep]]
+			if isAlias {
+				return fmt.Errorf("alias %s has no version %s", unversioned.name, r.request.Image[sep+1:])
+			}
+		}
+	}
 	if ok {
 		r.alias = &alias
 		r.image = r.alias.image
 		r.exec = r.alias.exec
+		err := r.checkAliasAccess(&alias)
+		if err != nil {
+			return err
+		}
 	} else {
 		if r.config.RestrictImages {
 			// free images not allowed
-			return fmt.Errorf("restrict-images in force, only aliased images allowed")
+			return r.deny(fmt.Errorf("restrict-images in force, only aliased images allowed"))
 		}
-		if *r.args.options.noImagePrefix {
-			r.image = r.args.image
+		if r.request.NoImagePrefix {
+			r.image = r.request.Image
 		} else {
-			r.image = r.autoPrefix(r.args.image)
+			r.image = r.autoPrefix(r.request.Image)
+		}
+		err := r.checkImageAccess()
+		if err != nil {
+			return err
 		}
 	}
 
+	err := validateImage(r.image)
+	if err != nil {
+		return err
+	}
+
 	switch {
 	case r.exec != "":
-		if *r.args.options.exec != "" {
+		if r.request.Exec != "" {
 			return fmt.Errorf("cannot specify executable with alias")
 		}
 
-	case *r.args.options.exec != "":
-		r.exec = *r.args.options.exec
+	case r.request.Exec != "":
+		r.exec = r.request.Exec
 
-	case *r.args.options.interactive && r.config.DefaultInteractiveCmd != "":
+	case r.mode == InteractiveMode && r.config.DefaultInteractiveCmd != "":
 		r.exec = r.config.DefaultInteractiveCmd
 	}
 	if r.exec != "" && r.exec[0] == '-' {
@@ -435,37 +781,32 @@ func (r *RunnerT) resolveImage() error {
 	return nil
 }
 
-func (r *RunnerT) formatVolumes() []string {
-	volumes := r.fragments.formatVolumes(r.requestedVolumes)
+// volumes returns the volumes to be mounted in the pod
+func (r *RunnerT) volumes() []VolumeSpec {
+	volumes := r.fragments.volumes(r.requestedVolumes)
 	if r.runWithSlave() {
-		volumes = append(volumes,
-			"--volume", fmt.Sprintf("%s,kind=host,source=%s", slaveBinVolume, r.config.ExecSlaveDir))
+		volumes = append(volumes, VolumeSpec{
+			Name:   slaveBinVolume,
+			Volume: fmt.Sprintf("kind=host,source=%s", r.config.ExecSlaveDir),
+			Mount:  fmt.Sprintf("target=%s", slaveBinDir),
+		})
 	}
 	return volumes
 }
 
-func (r *RunnerT) formatMounts() []string {
-	mounts := r.fragments.formatMounts(r.requestedVolumes)
-	if r.runWithSlave() {
-		mounts = append(mounts,
-			"--mount", fmt.Sprintf("volume=%s,target=%s", slaveBinVolume, slaveBinDir))
-	}
-	return mounts
-}
-
 func (r *RunnerT) buildFetchCommand(mode string) error {
-	r.fetchCommand = NewCommand(r.config.Rkt)
-	r.fetchCommand.AppendArgs(r.fragments.Options[mode][GeneralClass]...)
-	r.fetchCommand.AppendArgs("fetch")
-	r.fetchCommand.AppendArgs(r.fragments.Options[mode][FetchClass]...)
-	r.fetchCommand.AppendArgs(r.image)
+	r.fetchCommand = r.runtime.FetchCommand(&FetchSpec{
+		GeneralOptions: r.fragments.Options[mode][GeneralClass],
+		FetchOptions:   r.fragments.Options[mode][FetchClass],
+		Image:          r.image,
+	})
 	r.fetchCommand.SetEnviron(os.Environ())
 	return nil
 }
 
 func (r *RunnerT) validateCmdArgs() error {
 	// check for ---
-	for _, arg := range r.args.cmdArgs {
+	for _, arg := range r.request.Args {
 		if arg == "---" {
 			return fmt.Errorf("%s invalid", arg)
 		}
@@ -473,131 +814,157 @@ func (r *RunnerT) validateCmdArgs() error {
 	return nil
 }
 
-func (r *RunnerT) buildRunCommand(mode string) error {
-	r.runCommand = NewCommand(r.config.Rkt)
-	r.runCommand.AppendArgs(r.fragments.formatOptions(mode, GeneralClass)...)
-	r.runCommand.AppendArgs("run")
-
-	r.runCommand.AppendArgs("--uuid-file-save", uuidFilePath())
-	r.runCommand.AppendArgs("--set-env-file", envFilePath())
-	r.runCommand.AppendArgs(r.fragments.formatOptions(mode, RunClass)...)
+// runImage returns the image to run, which is the verified image ID
+// if the image is pinned, since its name may refer to any image.
+func (r *RunnerT) runImage() string {
+	if r.imageID != "" {
+		return r.imageID
+	}
+	return r.image
+}
 
-	r.runCommand.AppendArgs(r.formatVolumes()...)
-	r.runCommand.AppendArgs(r.image)
+func (r *RunnerT) buildRunCommand(mode string) error {
+	spec := &RunSpec{
+		GeneralOptions: r.fragments.formatOptions(mode, GeneralClass),
+		RunOptions:     r.fragments.formatOptions(mode, RunClass),
+		UUIDFile:       uuidFilePath(),
+		EnvFile:        envFilePath(),
+		Volumes:        r.volumes(),
+		Image:          r.runImage(),
+		ImageOptions:   r.fragments.formatOptions(mode, ImageClass),
+		Limits:         r.limits,
+	}
 
 	if r.worker != nil {
-		r.runCommand.AppendArgs("--name", r.worker.AppName)
+		spec.AppName = r.worker.AppName
 	}
 
-	r.runCommand.AppendArgs(r.formatMounts()...)
-	r.runCommand.AppendArgs(r.fragments.formatOptions(mode, ImageClass)...)
-
 	if r.runWithSlave() {
-		r.runCommand.AppendArgs("--exec", filepath.Join(slaveBinDir, slaveRunner), "--")
+		spec.Exec = filepath.Join(slaveBinDir, slaveRunner)
 		if r.config.PreserveCwd {
 			cwd, err := os.Getwd()
 			if err != nil {
 				return err
 			}
-			r.runCommand.AppendArgs("--cwd", cwd)
+			spec.Args = append(spec.Args, "--cwd", cwd)
 		}
 		if r.worker != nil {
-			r.runCommand.AppendArgs("--wait")
+			spec.Args = append(spec.Args, "--wait")
 		} else {
+			spec.Args = append(spec.Args, r.limits.slaveArgs()...)
 			if r.exec != "" {
-				r.runCommand.AppendArgs(r.exec)
+				spec.Args = append(spec.Args, r.exec)
 			}
 		}
 	} else {
-		if r.exec != "" {
-			r.runCommand.AppendArgs("--exec", r.exec, "--")
-		}
+		spec.Exec = r.exec
 	}
 
-	if r.worker == nil && len(r.args.cmdArgs) > 0 {
-		r.runCommand.AppendArgs(r.args.cmdArgs...)
+	if r.worker == nil && len(r.request.Args) > 0 {
+		spec.Args = append(spec.Args, r.request.Args...)
 	}
 
+	r.runCommand = r.runtime.RunCommand(spec)
 	r.runCommand.SetEnviron(BuildEnviron(r.hostEnviron))
 	return nil
 }
 
 func (r *RunnerT) buildEnterCommand() error {
-	r.enterCommand = NewCommand(r.config.Rkt)
-	r.enterCommand.AppendArgs("enter")
+	spec := &EnterSpec{}
 	if r.worker.FoundPod() {
-		r.enterCommand.AppendArgs(r.worker.UUID)
+		spec.UUID = r.worker.UUID
 	} else {
 		// placeholder, just for verbose output
-		r.enterCommand.AppendArgs("$uuid")
+		spec.UUID = "$uuid"
 	}
 
 	if r.enterWithSlave() {
-		r.enterCommand.AppendArgs(filepath.Join(slaveBinDir, slaveRunner))
+		spec.Args = append(spec.Args, filepath.Join(slaveBinDir, slaveRunner))
 		if r.config.PreserveCwd {
 			cwd, err := os.Getwd()
 			if err != nil {
 				return err
 			}
-			r.enterCommand.AppendArgs("--cwd", cwd)
+			spec.Args = append(spec.Args, "--cwd", cwd)
 		}
+		spec.Args = append(spec.Args, r.limits.slaveArgs()...)
 		environmentUpdate := r.environmentUpdate()
 		if environmentUpdate != nil {
-			if *r.args.options.verbose {
+			if r.request.Verbose {
 				fmt.Fprintf(os.Stderr, "environment-update: %v\n", environmentUpdate)
 			}
 			for _, name := range environmentUpdate {
 				value, ok := r.podEnviron[name]
 				if ok {
-					r.enterCommand.AppendArgs("--set-env", fmt.Sprintf("%s=%s", name, value))
+					spec.Args = append(spec.Args, "--set-env", fmt.Sprintf("%s=%s", name, value))
 				}
 			}
 		}
 	}
 
 	if r.exec != "" {
-		r.enterCommand.AppendArgs(r.exec)
+		spec.Args = append(spec.Args, r.exec)
 	}
 
-	if len(r.args.cmdArgs) > 0 {
-		r.enterCommand.AppendArgs(r.args.cmdArgs...)
+	if len(r.request.Args) > 0 {
+		spec.Args = append(spec.Args, r.request.Args...)
 	}
 
+	r.enterCommand = r.runtime.EnterCommand(spec)
 	return nil
 }
 
-func (r *RunnerT) Execute() error {
-	// different functionality depending on options, see NewRunner()
+// Execute carries out the request, writing a single audit record for
+// it, however it turns out.
+func (r *RunnerT) Execute() (err error) {
+	defer func() {
+		r.finish(err)
+	}()
+
+	err = r.prepare()
+	if err != nil {
+		return err
+	}
+
+	// different functionality depending on options, see initialize()
 	switch {
-	case *r.args.options.listAlias:
-		r.printAliases(os.Stdout)
+	case r.request.CheckConfig:
+		return r.checkConfig(os.Stdout, r.configFile, r.templateVariables(r.user))
+
+	case r.request.ListAlias:
+		return r.listAliases(os.Stdout, r.request.Format, r.aliasKeys())
+
+	case r.request.Pin:
+		if getuid() != 0 || geteuid() != 0 {
+			return ErrNotRoot
+		}
+		return r.pin(os.Stdout)
+
+	case r.request.InstallShims != "":
+		if !r.request.DryRun && (getuid() != 0 || geteuid() != 0) {
+			return ErrNotRoot
+		}
+		return r.installShims(os.Stdout, r.request.InstallShims, r.request.DryRun)
+
+	case r.request.Search != "":
+		keys := r.searchAliasKeys(r.request.Search)
+		format := r.request.Format
+		if format == "" || format == TextFormat {
+			r.printAliasesWithDescription(os.Stdout, keys)
+			return nil
+		}
+		return r.listAliases(os.Stdout, format, keys)
 
 	default:
-		if !*r.args.options.dryRun {
-			if syscall.Getuid() != 0 || syscall.Geteuid() != 0 {
+		if !r.request.DryRun {
+			if getuid() != 0 || geteuid() != 0 {
 				return ErrNotRoot
 			}
 
-			if r.runCommand != nil {
-				err := r.fetchAndRun()
-				if err != nil {
-					return err
-				}
-			}
-			if r.worker != nil && !*r.args.options.prepare {
-				err := r.buildEnterCommand()
-				if err != nil {
-					return err
-				}
-
-				err = r.enter()
-				if err != nil {
-					return err
-				}
-			}
-		} else if *r.args.options.verbose {
+			return r.launch()
+		} else if r.request.Verbose {
 			r.printFetchAndRun()
-			if r.worker != nil && !*r.args.options.prepare {
+			if r.worker != nil && !r.request.Prepare {
 				err := r.buildEnterCommand()
 				if err != nil {
 					return err
@@ -609,13 +976,119 @@ func (r *RunnerT) Execute() error {
 	return nil
 }
 
+// launch runs the container, or enters the worker pod, or both
+func (r *RunnerT) launch() error {
+	if r.runCommand != nil {
+		err := r.verifyDigest()
+		if err != nil {
+			return err
+		}
+		if r.imageID != "" {
+			// run exactly the image which was verified
+			err = r.buildRunCommand(r.mode)
+			if err != nil {
+				return err
+			}
+		}
+		err = r.fetchAndRun()
+		if err != nil {
+			return err
+		}
+	}
+	if r.worker != nil && !r.request.Prepare {
+		err := r.buildEnterCommand()
+		if err != nil {
+			return err
+		}
+
+		err = r.enter()
+		if _, isTimeout := err.(*TimeoutError); isTimeout && r.runCommand != nil {
+			// don't leave a fresh worker pod for the sake of a runaway command
+			WarnOnFailure(r.worker.StopIfUnused())
+		}
+		r.worker.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditRecord returns the audit record for this invocation,
+// which resulted in err.
+func (r *RunnerT) auditRecord(err error) *AuditRecord {
+	rec := &AuditRecord{
+		Time:       r.startTime,
+		Alias:      r.aliasName(),
+		Image:      r.image,
+		Exec:       r.exec,
+		Volumes:    r.request.Volumes,
+		PodUUID:    r.podUUID,
+		ExitStatus: ExitStatus(err),
+		Duration:   time.Since(r.startTime).Seconds(),
+	}
+	if r.user != nil {
+		rec.User = r.user.Username
+		rec.Uid = r.user.Uid
+	}
+	if r.denied != nil {
+		rec.Denied = r.denied.Error()
+	}
+	rec.Cwd, _ = os.Getwd()
+	if r.worker != nil {
+		rec.PodUUID = r.worker.UUID
+		if r.runCommand != nil {
+			rec.Worker = NewWorkerPod
+		} else {
+			rec.Worker = ReusedWorkerPod
+		}
+	}
+	return rec
+}
+
+// finish writes the audit record for this invocation, which resulted
+// in err, unless already written.  Nothing is written for a dry run,
+// since the config may be the user's own.
+func (r *RunnerT) finish(err error) {
+	r.auditOnce.Do(func() {
+		if r.request.DryRun {
+			return
+		}
+		audit := r.auditConfig()
+		if !audit.isEnabled() {
+			return
+		}
+		werr := audit.write(r.auditRecord(err))
+		if werr != nil {
+			Warnf("failed to write audit record: %v", werr)
+		}
+	})
+}
+
+// auditConfig returns where audit records are written, reading the
+// config file for it if the request failed before the config was read.
+func (r *RunnerT) auditConfig() *AuditT {
+	if r.configRead {
+		return &r.config.Audit
+	}
+	var c configT
+	readConfig(r.configFile, &c)
+	return &c.Audit
+}
+
+// deny records the refusal of the request, for the audit record
+func (r *RunnerT) deny(denied error) error {
+	r.denied = denied
+	return denied
+}
+
 // printFetchAndRun just prints the commands which would be used
 func (r *RunnerT) printFetchAndRun() {
 	if r.fetchCommand != nil {
 		r.fetchCommand.Print(os.Stderr)
 	}
 	if r.runCommand != nil {
-		if *r.args.options.printEnv {
+		if r.request.PrintEnv {
 			r.printEnvironment(os.Stderr)
 		}
 		r.runCommand.Print(os.Stderr)
@@ -629,7 +1102,7 @@ func (r *RunnerT) fetchAndRun() error {
 	var err error
 
 	if r.fetchCommand != nil {
-		if *r.args.options.verbose {
+		if r.request.Verbose {
 			r.fetchCommand.Print(os.Stderr)
 		}
 
@@ -657,11 +1130,16 @@ func (r *RunnerT) fetchAndRun() error {
 	if r.worker != nil {
 		err = r.runCommand.StartDaemon()
 	} else {
+		r.runCommand.SetTimeout(r.timeout, r.signalGracePeriod())
 		err = r.runCommand.Start()
+		if err == nil {
+			r.setActive(r.runCommand)
+			defer r.setActive(nil)
+		}
 	}
 	if err == nil {
-		if *r.args.options.verbose {
-			if *r.args.options.printEnv {
+		if r.request.Verbose {
+			if r.request.PrintEnv {
 				r.printEnvironment(os.Stderr)
 			}
 			r.runCommand.Print(os.Stderr)
@@ -686,11 +1164,15 @@ func (r *RunnerT) fetchAndRun() error {
 				}
 			}
 		} else {
-			// don't care about the UUID, just wait for the pod to exit
+			// the UUID is only for the audit record
 			err = r.runCommand.Wait()
+			uuid, uuidErr := ioutil.ReadFile(uuidFilePath())
+			if uuidErr == nil {
+				r.podUUID = strings.TrimSpace(string(uuid))
+			}
 		}
 	} else {
-		if *r.args.options.verbose {
+		if r.request.Verbose {
 			r.runCommand.Print(os.Stderr)
 		}
 	}
@@ -698,24 +1180,33 @@ func (r *RunnerT) fetchAndRun() error {
 	return err
 }
 
+// RemoveTempFiles removes the temporary files, if they were created
 func (r *RunnerT) RemoveTempFiles() {
 	os.Remove(uuidFilePath())
-	WarnOnFailure(os.Remove(envFilePath()))
-	WarnOnFailure(os.Remove(masterRunDir()))
+	for _, path := range []string{envFilePath(), masterRunDir()} {
+		err := os.Remove(path)
+		if !os.IsNotExist(err) {
+			WarnOnFailure(err)
+		}
+	}
 }
 
-// enter enters the pod.  In the case of not having also started the pod,
-// if successful, it does not return.
+// enter enters the pod.
 func (r *RunnerT) enter() error {
-	if *r.args.options.verbose {
+	if r.request.Verbose {
 		r.enterCommand.Print(os.Stderr)
 	}
 	r.enterCommand.PreserveFile(r.worker.Podlock)
-	// if we also started a pod, then simply run the enter command
-	if r.runCommand != nil {
-		// need to stay for the cleanup
-		return r.enterCommand.Run()
-	} else {
-		return r.enterCommand.Exec()
+	if r.worker.Sessionlock != nil {
+		r.enterCommand.PreserveFile(r.worker.Sessionlock)
+	}
+	r.enterCommand.SetTimeout(r.timeout, r.signalGracePeriod())
+	// we stay around, for cleanup, signal forwarding, and the audit record
+	err := r.enterCommand.Start()
+	if err != nil {
+		return err
 	}
+	r.setActive(r.enterCommand)
+	defer r.setActive(nil)
+	return r.enterCommand.Wait()
 }
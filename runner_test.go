@@ -0,0 +1,485 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+const testConfig = `
+[options.common]
+general = ["--insecure-options=image"]
+run = ["--net=host"]
+image = ["--user={{.Uid}}", "--group={{.Gid}}"]
+
+[options.interactive]
+run = ["--interactive"]
+
+[volume.home]
+volume = "kind=host,source={{.HomeDir}}"
+mount = "target=/home/{{.Username}}"
+
+[volume.dataset]
+volume = "kind=host,source=/dataset"
+mount = "target=/dataset"
+on-request = true
+
+[alias.busybox_]
+image = "example.com/tools/busybox:1.0"
+exec = ["/bin/grep"]
+`
+
+const testWorkerConfig = `
+worker-pods = true
+exec-slave-dir = "$SLAVEDIR"
+
+[options.common]
+general = ["--insecure-options=image"]
+image = ["--user={{.Uid}}"]
+
+[alias.busybox_]
+image = "example.com/tools/busybox:1.0"
+exec = ["/bin/grep"]
+`
+
+func TestRunDryRun(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig)
+
+	stderr, status := h.rktRun("--dry-run", "-v", "grep", "-q", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	expected := h.rkt + " --insecure-options=image run --uuid-file-save $ROOT/runner-$PID/uuid --set-env-file $ROOT/runner-$PID/env --net=host --volume home,kind=host,source=" + h.user.HomeDir + " example.com/tools/busybox:1.0 --mount volume=home,target=/home/$USER --user=" + h.user.Uid + " --group=" + h.user.Gid + " --exec /bin/grep -- -q needle\n"
+	if h.normalize(stderr) != h.normalize(expected) {
+		t.Errorf("rkt-run -v printed:\n%s\nexpected:\n%s", h.normalize(stderr), h.normalize(expected))
+	}
+	h.expectInvocations()
+}
+
+func TestRun(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig)
+
+	stderr, status := h.rktRun("--volume", "dataset", "grep", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	invocations := h.invocations()
+	if len(invocations) != 1 {
+		t.Fatalf("expected a single rkt invocation, got:\n%s", formatInvocations(invocations))
+	}
+	run := strings.Join(invocations[0], " ")
+	for _, expected := range []string{
+		"rkt --insecure-options=image run --uuid-file-save $ROOT/runner-$PID/uuid --set-env-file $ROOT/runner-$PID/env --net=host ",
+		" --volume dataset,kind=host,source=/dataset ",
+		" --mount volume=dataset,target=/dataset ",
+		" --exec /bin/grep -- needle",
+	} {
+		if !strings.Contains(run, expected) {
+			t.Errorf("rkt run %s\nmissing %s", run, expected)
+		}
+	}
+
+	// temporary files are cleaned up
+	entries, err := ioutil.ReadDir(h.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unexpected files left in %s: %v", h.root, entries)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig)
+	h.script("run", "", 3)
+
+	_, status := h.rktRun("grep", "needle")
+	if status != 3 {
+		t.Errorf("exit status %d, expected exit status of rkt run", status)
+	}
+}
+
+func TestEnterFailure(t *testing.T) {
+	for _, exit := range []int{1, 139} {
+		h := newHarness(t)
+		h.writeConfig(testWorkerConfig)
+		h.script("status", "state=running\n", 0)
+		h.script("enter", "", exit)
+
+		// new worker pod
+		_, status := h.rktRun("grep", "needle")
+		if status != exit {
+			t.Errorf("new worker exit status %d, expected %d", status, exit)
+		}
+
+		// existing worker pod
+		h.script("list", listLine(fakeUUID, WORKER_APPNAME_PREFIX+h.user.Username, "example.com/tools/busybox:1.0", "running", ""), 0)
+		h.script("cat-manifest", podManifest(h.user.Uid), 0)
+		_, status = h.rktRun("grep", "needle")
+		if status != exit {
+			t.Errorf("existing worker exit status %d, expected %d", status, exit)
+		}
+	}
+}
+
+func TestRunInvalidVolume(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig)
+
+	stderr, status := h.rktRun("--volume", "home", "grep", "needle")
+	if status == 0 || !strings.Contains(stderr, "invalid volume: home") {
+		t.Errorf("expected invalid volume, got status %d: %s", status, stderr)
+	}
+	h.expectInvocations()
+}
+
+func TestRunNewWorker(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testWorkerConfig)
+	h.script("status", "state=running\n", 0)
+
+	stderr, status := h.rktRun("grep", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "--insecure-options=image", "run",
+			"--uuid-file-save", "$ROOT/runner-$PID/uuid",
+			"--set-env-file", "$ROOT/runner-$PID/env",
+			"--volume", "rktrunner-bin,kind=host,source=$SLAVEDIR",
+			"example.com/tools/busybox:1.0",
+			"--name", "rktrunner-$USER",
+			"--mount", "volume=rktrunner-bin,target=/usr/lib/rktrunner",
+			"--user=" + h.user.Uid,
+			"--exec", "/usr/lib/rktrunner/rkt-run-slave", "--", "--wait"},
+		[]string{"rkt", "status", fakeUUID},
+		[]string{"rkt", "enter", fakeUUID, "/bin/grep", "needle"},
+	)
+	if !exists(filepath.Join(h.root, podPrefix+fakeUUID)) {
+		t.Errorf("worker pod dir not created")
+	}
+}
+
+func TestRunExistingWorker(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testWorkerConfig)
+	h.createWorkerPodDirWithFingerprint(fakeUUID, h.workerFingerprint(RunRequest{Image: "grep"}))
+	h.script("list", listLine(fakeUUID, WORKER_APPNAME_PREFIX+h.user.Username, "example.com/tools/busybox:1.0", "running", ""), 0)
+	h.script("cat-manifest", podManifest(h.user.Uid), 0)
+
+	stderr, status := h.rktRun("grep", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "cat-manifest", fakeUUID},
+		[]string{"rkt", "enter", fakeUUID, "/bin/grep", "needle"},
+	)
+	for _, name := range []string{"last-use", "session-end"} {
+		if !exists(filepath.Join(h.root, podPrefix+fakeUUID, name)) {
+			t.Errorf("worker pod %s not recorded", name)
+		}
+	}
+}
+
+func TestRunExistingWorkerOtherVolumes(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testWorkerConfig + `
+[volume.dataset]
+volume = "kind=host,source=/dataset"
+mount = "target=/dataset"
+on-request = true
+`)
+	const otherUUID = "11111111-1111-1111-1111-111111111111"
+	h.createWorkerPodDirWithFingerprint(otherUUID, h.workerFingerprint(RunRequest{Image: "grep"}))
+	h.script("list", listLine(otherUUID, WORKER_APPNAME_PREFIX+h.user.Username, "example.com/tools/busybox:1.0", "running", ""), 0)
+	h.script("status", "state=running\n", 0)
+
+	stderr, status := h.rktRun("--volume", "dataset", "grep", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	invocations := h.invocations()
+	if len(invocations) != 4 || invocations[1][2] != "run" || invocations[3][1] != "enter" || invocations[3][2] != fakeUUID {
+		t.Errorf("expected new worker pod, got:\n%s", formatInvocations(invocations))
+	}
+
+	// the new worker pod is reused only with the same volumes
+	fingerprint, err := ioutil.ReadFile(filepath.Join(h.root, podPrefix+fakeUUID, "fingerprint"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := h.workerFingerprint(RunRequest{Image: "grep", Volumes: []string{"dataset"}})
+	if strings.TrimSpace(string(fingerprint)) != expected {
+		t.Errorf("new worker pod has fingerprint %s, expected %s", fingerprint, expected)
+	}
+}
+
+func TestRunExistingWorkerOtherDisplay(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testWorkerConfig + `environment-update = ["DISPLAY"]
+
+[environment]
+DISPLAY = "{{.DISPLAY}}"
+`)
+	h.createWorkerPodDirWithFingerprint(fakeUUID, h.workerFingerprint(RunRequest{Image: "grep", Environ: []string{"DISPLAY=:0"}}))
+	h.script("list", listLine(fakeUUID, WORKER_APPNAME_PREFIX+h.user.Username, "example.com/tools/busybox:1.0", "running", ""), 0)
+	h.script("cat-manifest", podManifest(h.user.Uid), 0)
+	h.environ = append(h.environ, "DISPLAY=:1")
+
+	stderr, status := h.rktRun("grep", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "cat-manifest", fakeUUID},
+		[]string{"rkt", "enter", fakeUUID, "/usr/lib/rktrunner/rkt-run-slave", "--set-env", "DISPLAY=:1", "/bin/grep", "needle"},
+	)
+}
+
+func TestRunExistingWorkerLimits(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testWorkerConfig + `pids-limit = 256
+nofile = 1024
+`)
+	h.createWorkerPodDirWithFingerprint(fakeUUID, h.workerFingerprint(RunRequest{Image: "grep"}))
+	h.script("list", listLine(fakeUUID, WORKER_APPNAME_PREFIX+h.user.Username, "example.com/tools/busybox:1.0", "running", ""), 0)
+	h.script("cat-manifest", podManifest(h.user.Uid), 0)
+
+	stderr, status := h.rktRun("--pids-limit", "64", "grep", "needle")
+	if status != 0 {
+		t.Fatalf("exit status %d: %s", status, stderr)
+	}
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "cat-manifest", fakeUUID},
+		[]string{"rkt", "enter", fakeUUID, "/usr/lib/rktrunner/rkt-run-slave", "--pids-limit", "64", "--nofile", "1024", "/bin/grep", "needle"},
+	)
+}
+
+func TestRunAliasAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		groups  string
+		allowed bool
+	}{
+		{"unrestricted", "", "users", true},
+		{"allowed group", "allow-groups = [\"licensed\"]\n", "users,licensed", true},
+		{"not in allowed group", "allow-groups = [\"licensed\"]\n", "users", false},
+		{"denied group", "deny-groups = [\"students\"]\n", "users,students", false},
+		{"default denied", "[default-access]\ndeny-groups = [\"users\"]\n", "users", false},
+		{"default overridden", "allow-groups = [\"users\"]\n[default-access]\ndeny-groups = [\"users\"]\n", "users", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.writeConfig(testConfig + test.config)
+			h.environ = append(h.environ, testGroupsEnv+"="+test.groups)
+
+			stderr, status := h.rktRun("--dry-run", "grep", "needle")
+			if test.allowed && status != 0 {
+				t.Errorf("exit status %d: %s", status, stderr)
+			}
+			if !test.allowed && (status == 0 || !strings.Contains(stderr, "alias busybox_ not permitted for user "+h.user.Username)) {
+				t.Errorf("expected alias not permitted, got status %d: %s", status, stderr)
+			}
+		})
+	}
+}
+
+func TestRunImageAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		groups   string
+		image    string
+		expected string
+	}{
+		{"aliased image allowed", "allow-groups = [\"licensed\"]\n", "users,licensed", "example.com/tools/busybox:1.0", ""},
+		{"aliased image denied", "allow-groups = [\"licensed\"]\n", "users", "example.com/tools/busybox:1.0", "by alias busybox_"},
+		{"versioned image denied", "allow-groups = [\"licensed\"]\n[alias.busybox_.versions]\n\"1.1\" = \"example.com/tools/busybox:1.1\"\n", "users", "example.com/tools/busybox:1.1", "by alias busybox_"},
+		{"other tag denied", "allow-groups = [\"licensed\"]\n", "users", "example.com/tools/busybox:2.0", "by alias busybox_"},
+		{"digest denied", "allow-groups = [\"licensed\"]\n", "users", "docker://example.com/tools/busybox@" + testSha256, "by alias busybox_"},
+		{"uppercase other tag denied", "allow-groups = [\"licensed\"]\n[alias.licensed_]\nimage = \"example.com/Tools/x:1.0\"\nallow-groups = [\"licensed\"]\n", "users", "example.com/Tools/x:2.0", "by alias licensed_"},
+		{"image ID denied", "", "users,licensed", "sha512-0123456789abcdef", "image ID sha512-0123456789abcdef not permitted"},
+		{"unaliased image allowed", "allow-groups = [\"licensed\"]\n", "users", "example.com/tools/other:1.0", ""},
+		{"unaliased image default denied", "[default-access]\ndeny-groups = [\"users\"]\n", "users", "example.com/tools/other:1.0", "image example.com/tools/other:1.0 not permitted"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			path := h.auditConfig(testConfig + test.config)
+			h.environ = append(h.environ, testGroupsEnv+"="+test.groups)
+
+			stderr, status := h.rktRun(test.image)
+			if test.expected == "" {
+				if status != 0 {
+					t.Errorf("exit status %d: %s", status, stderr)
+				}
+				return
+			}
+			if status == 0 || !strings.Contains(stderr, "not permitted for user "+h.user.Username) || !strings.Contains(stderr, test.expected) {
+				t.Errorf("expected image not permitted, got status %d: %s", status, stderr)
+			}
+			records := readAuditRecords(t, path)
+			if len(records) != 1 || !strings.Contains(records[0].Denied, test.expected) || records[0].Image != test.image {
+				t.Errorf("expected denied audit record, got %+v", records)
+			}
+		})
+	}
+}
+
+func TestRunVolumeAccess(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig + `
+[volume.dataset-restricted]
+volume = "kind=host,source=/dataset-restricted"
+mount = "target=/dataset-restricted"
+on-request = true
+allow-groups = ["restricted"]
+`)
+	h.environ = append(h.environ, testGroupsEnv+"=users")
+
+	stderr, status := h.rktRun("--dry-run", "--volume", "dataset-restricted", "grep", "needle")
+	if status == 0 || !strings.Contains(stderr, "volume dataset-restricted not permitted for user "+h.user.Username) {
+		t.Errorf("expected volume not permitted, got status %d: %s", status, stderr)
+	}
+
+	stderr, status = h.rktRun("--dry-run", "--volume", "dataset", "grep", "needle")
+	if status != 0 {
+		t.Errorf("exit status %d: %s", status, stderr)
+	}
+
+	h.environ = append(h.environ, testGroupsEnv+"=users,restricted")
+	stderr, status = h.rktRun("--dry-run", "-v", "--volume", "dataset-restricted", "grep", "needle")
+	if status != 0 {
+		t.Errorf("exit status %d: %s", status, stderr)
+	}
+	for _, expected := range []string{
+		" --volume dataset-restricted,kind=host,source=/dataset-restricted ",
+		" --mount volume=dataset-restricted,target=/dataset-restricted ",
+	} {
+		if !strings.Contains(stderr, expected) {
+			t.Errorf("rkt-run --volume dataset-restricted: %s\nmissing %s", stderr, expected)
+		}
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig)
+
+	status, received := h.signalRktRun("run", nil, nil, "--timeout", "100ms", "grep", "needle")
+	if status != ExitTimeout {
+		t.Errorf("exit status %d, expected %d", status, ExitTimeout)
+	}
+	if expected := []syscall.Signal{syscall.SIGTERM}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("fake rkt received %v, expected %v", received, expected)
+	}
+}
+
+func TestRunTimeoutExceedsAlias(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig + "timeout = \"1h\"\n")
+
+	stderr, status := h.rktRun("--dry-run", "--timeout", "2h", "grep", "needle")
+	if status == 0 || !strings.Contains(stderr, "timeout 2h0m0s exceeds maximum 1h0m0s for busybox_") {
+		t.Errorf("expected timeout exceeds maximum, got status %d: %s", status, stderr)
+	}
+}
+
+func TestEnterTimeoutNewWorker(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testWorkerConfig + "timeout = \"100ms\"\n")
+	h.script("status", "state=running\n", 0)
+
+	status, _ := h.signalRktRun("enter", nil, nil, "grep", "needle")
+	if status != ExitTimeout {
+		t.Errorf("exit status %d, expected %d", status, ExitTimeout)
+	}
+	invocations := h.invocations()
+	if last := invocations[len(invocations)-1]; !reflect.DeepEqual(last, []string{"rkt", "stop", fakeUUID}) {
+		t.Errorf("expected worker pod to be stopped, got:\n%s", formatInvocations(invocations))
+	}
+	if exists(filepath.Join(h.root, podPrefix+fakeUUID)) {
+		t.Errorf("worker pod dir not removed")
+	}
+}
+
+func TestRunMemoryExceedsMaximum(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig("memory = \"4Gi\"\n[group-limits.assembly]\nmax-memory = \"64Gi\"\n" + testConfig + "max-memory = \"256Gi\"\n")
+
+	tests := []struct {
+		groups   string
+		expected string
+	}{
+		{"assembly", "memory 128Gi exceeds maximum 64Gi for your groups"},
+		{"users", ""},
+	}
+	for _, test := range tests {
+		h.environ = append(h.environ, testGroupsEnv+"="+test.groups)
+		stderr, status := h.rktRun("--dry-run", "--memory", "128Gi", "grep", "needle")
+		if test.expected == "" {
+			if status != 0 {
+				t.Errorf("groups %s: exit status %d: %s", test.groups, status, stderr)
+			}
+		} else if status == 0 || !strings.Contains(stderr, test.expected) {
+			t.Errorf("groups %s: expected %s, got status %d: %s", test.groups, test.expected, status, stderr)
+		}
+	}
+}
+
+func TestRunAliasVersion(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig + `
+[alias.samtools_]
+exec = ["samtools"]
+default-version = "1.4.1"
+
+[alias.samtools_.versions]
+"1.3.1" = "quay.io/biocontainers/samtools:1.3.1--5"
+"1.4.1" = "quay.io/biocontainers/samtools:1.4.1--0"
+`)
+
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{"samtools", " quay.io/biocontainers/samtools:1.4.1--0 "},
+		{"samtools_", " quay.io/biocontainers/samtools:1.4.1--0 "},
+		{"samtools@1.3.1", " quay.io/biocontainers/samtools:1.3.1--5 "},
+		{"samtools_@1.3.1", " quay.io/biocontainers/samtools:1.3.1--5 "},
+		{"samtools@1.9", "alias samtools_ has no version 1.9"},
+	}
+	for _, test := range tests {
+		stderr, status := h.rktRun("--dry-run", "-v", test.image)
+		if !strings.Contains(stderr, test.expected) {
+			t.Errorf("%s: expected %s, got status %d: %s", test.image, test.expected, status, stderr)
+		}
+	}
+}
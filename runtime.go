@@ -0,0 +1,151 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VolumeSpec is a volume to be made available in a pod, with its
+// mount point in the app.  The Volume and Mount parameters are as
+// written in the config file, either may be empty.
+type VolumeSpec struct {
+	Name   string
+	Volume string
+	Mount  string
+}
+
+// FetchSpec describes an image fetch.
+type FetchSpec struct {
+	GeneralOptions []string
+	FetchOptions   []string
+	Image          string
+}
+
+// RunSpec describes a pod to run.
+type RunSpec struct {
+	GeneralOptions []string
+	RunOptions     []string
+	UUIDFile       string
+	EnvFile        string
+	Volumes        []VolumeSpec
+	Image          string
+	AppName        string
+	ImageOptions   []string
+	Exec           string
+	Args           []string
+
+	// Limits on cpu and memory are applied by the runtime, the others
+	// are applied by rkt-run-slave, and so are not the runtime's concern.
+	Limits ResourceLimitsT
+}
+
+// ImageInfo describes an image which has been fetched, where ID is
+// the hash of the image, e.g. sha512-...
+type ImageInfo struct {
+	ID   string
+	Name string
+}
+
+// PodInfo describes an existing pod.  Its state is given by Status.
+type PodInfo struct {
+	UUID string
+	Apps []AppInfo
+}
+
+// AppInfo describes an app within a pod, where User is the uid as which
+// it runs, and Rootfs is the host path of its root filesystem.
+type AppInfo struct {
+	Name   string
+	Image  string
+	User   string
+	Rootfs string
+}
+
+// EnterSpec describes a command to run inside an existing pod.
+type EnterSpec struct {
+	UUID string
+	Args []string
+}
+
+// Runtime is a container runtime backend, which knows how to build the
+// command lines for fetching, running and entering, and how to query and
+// control existing pods.
+type Runtime interface {
+	// Name returns the name of the runtime, as used in the config file.
+	Name() string
+
+	FetchCommand(spec *FetchSpec) *CommandT
+	RunCommand(spec *RunSpec) *CommandT
+	EnterCommand(spec *EnterSpec) *CommandT
+
+	// Status returns the state of the pod, e.g. running or exited.
+	Status(uuid string) (string, error)
+
+	// VisitPods visits all pods, until the walker returns false.
+	VisitPods(walker func(*VisitedPod) bool) error
+
+	// Images returns all images already fetched.
+	Images() ([]ImageInfo, error)
+
+	Stop(uuid string) error
+
+	// Pod returns the apps within the pod.
+	Pod(uuid string) (*PodInfo, error)
+}
+
+const DefaultRuntime = RktRuntime
+
+type runtimeConstructor func(c *configT) (Runtime, error)
+
+// runtimes are all the available backends, by config file name
+var runtimes = map[string]runtimeConstructor{
+	RktRuntime: newRktRuntimeFromConfig,
+}
+
+func runtimeNames() []string {
+	names := make([]string, 0, len(runtimes))
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewRuntime returns the runtime selected in the config.
+func NewRuntime(c *configT) (Runtime, error) {
+	name := c.Runtime
+	if name == "" {
+		name = DefaultRuntime
+	}
+	newRuntime, ok := runtimes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown runtime %s, expected one of %s", name, strings.Join(runtimeNames(), ", "))
+	}
+	return newRuntime(c)
+}
+
+// NewRuntimeFromConfigFile returns the runtime selected in the config file,
+// for programs other than rkt-run which need to run the same runtime.
+func NewRuntimeFromConfigFile(path string) (Runtime, error) {
+	var c configT
+	err := GetConfig(path, &c)
+	if err != nil {
+		return nil, fmt.Errorf("configuration error: %v", err)
+	}
+	return NewRuntime(&c)
+}
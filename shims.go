@@ -0,0 +1,162 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DefaultHelper is where rkt-run-helper is installed, see rktrunner.spec
+const DefaultHelper = "/usr/libexec/rktrunner/rkt-run-helper"
+
+// helper returns the path of rkt-run-helper, to which shims are linked
+func (c *configT) helper() string {
+	if c.Helper != "" {
+		return c.Helper
+	}
+	return DefaultHelper
+}
+
+// shimNames returns the exec aliases, in order, omitting versioned aliases
+func (r *RunnerT) shimNames() []string {
+	var names []string
+	for _, key := range r.aliasKeys() {
+		alias := r.aliases[key]
+		if alias.exec != "" && key == filepath.Base(alias.exec) {
+			names = append(names, key)
+		}
+	}
+	return names
+}
+
+// pathConflicts returns the executables on the path with the same
+// name as the shim, other than in the shim directory itself.
+func pathConflicts(path, dir, name string) []string {
+	var conflicts []string
+	for _, pathDir := range filepath.SplitList(path) {
+		if pathDir == "" || filepath.Clean(pathDir) == filepath.Clean(dir) {
+			continue
+		}
+		candidate := filepath.Join(pathDir, name)
+		info, err := os.Stat(candidate)
+		if err == nil && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 {
+			conflicts = append(conflicts, candidate)
+		}
+	}
+	return conflicts
+}
+
+// isHelper returns whether the symlink target is rkt-run-helper, either
+// the one configured, or one previously installed elsewhere, as
+// recognised by its name.
+func isHelper(target, helper string) bool {
+	name := filepath.Base(target)
+	return target == helper || name == filepath.Base(helper) || name == filepath.Base(DefaultHelper)
+}
+
+// installShims makes dir contain a symlink to rkt-run-helper for each
+// exec alias, creating and updating links as required, and removing
+// links to the helper for aliases which no longer exist.  Actions and
+// conflicts are reported to w.  Existing files which are not symlinks
+// to a helper are left alone, and reported as errors.
+func (r *RunnerT) installShims(w io.Writer, dir string, dryRun bool) error {
+	helper := r.config.helper()
+	names := r.shimNames()
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var nConflicts int
+	for _, name := range names {
+		shim := filepath.Join(dir, name)
+		info, err := os.Lstat(shim)
+		switch {
+		case os.IsNotExist(err):
+			fmt.Fprintf(w, "created %s\n", name)
+			if !dryRun {
+				err = os.Symlink(helper, shim)
+				if err != nil {
+					return err
+				}
+			}
+
+		case err != nil:
+			return err
+
+		case info.Mode()&os.ModeSymlink == 0:
+			fmt.Fprintf(w, "conflict %s: %s exists and is not a symlink\n", name, shim)
+			nConflicts++
+
+		default:
+			target, err := os.Readlink(shim)
+			if err != nil {
+				return err
+			}
+			switch {
+			case target == helper:
+			case !isHelper(target, helper):
+				fmt.Fprintf(w, "conflict %s: %s is a link to %s\n", name, shim, target)
+				nConflicts++
+			default:
+				fmt.Fprintf(w, "updated %s, was link to %s\n", name, target)
+				if !dryRun {
+					err = os.Remove(shim)
+					if err == nil {
+						err = os.Symlink(helper, shim)
+					}
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		for _, conflict := range pathConflicts(r.hostEnviron["PATH"], dir, name) {
+			fmt.Fprintf(w, "warning: %s: %s is also on PATH\n", name, conflict)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if wanted[name] || entry.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		shim := filepath.Join(dir, name)
+		target, err := os.Readlink(shim)
+		if err == nil && isHelper(target, helper) {
+			fmt.Fprintf(w, "removed %s\n", name)
+			if !dryRun {
+				err = os.Remove(shim)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if nConflicts > 0 {
+		return fmt.Errorf("%d shims not installed in %s", nConflicts, dir)
+	}
+	return nil
+}
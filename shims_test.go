@@ -0,0 +1,102 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstallShims(t *testing.T) {
+	h := newHarness(t)
+	helper := filepath.Join(h.slaveDir, "rkt-run-helper")
+	const oldHelper = "/usr/local/libexec/rktrunner/rkt-run-helper"
+	h.writeConfig("helper = \"" + helper + "\"\n" + testConfig + `
+[alias.ruby_]
+image = "docker://ruby"
+exec = ["ruby", "irb"]
+
+[alias.samtools_]
+exec = ["samtools"]
+default-version = "1.4.1"
+
+[alias.samtools_.versions]
+"1.4.1" = "quay.io/biocontainers/samtools:1.4.1--0"
+`)
+
+	shimDir := filepath.Join(h.workDir, "shims")
+	pathDir := filepath.Join(h.workDir, "bin")
+	for _, dir := range []string{shimDir, pathDir} {
+		err := os.Mkdir(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, setup := range []func() error{
+		func() error { return ioutil.WriteFile(filepath.Join(pathDir, "grep"), nil, 0755) },
+		func() error { return ioutil.WriteFile(filepath.Join(shimDir, "irb"), nil, 0755) },
+		func() error { return os.Symlink("/usr/bin/ruby", filepath.Join(shimDir, "ruby")) },
+		func() error { return os.Symlink(oldHelper, filepath.Join(shimDir, "samtools")) },
+		func() error { return os.Symlink(oldHelper, filepath.Join(shimDir, "blast")) },
+		func() error { return os.Symlink(helper, filepath.Join(shimDir, "julia")) },
+		func() error { return os.Symlink("/usr/bin/perl", filepath.Join(shimDir, "perl")) },
+	} {
+		err := setup()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.environ = append(h.environ, "PATH="+shimDir+":"+pathDir)
+
+	cmd, stderr := h.rktRunCommand("--install-shims", shimDir)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if err == nil || !strings.Contains(stderr.String(), "2 shims not installed") {
+		t.Errorf("expected shim not installed, got %v: %s", err, stderr.String())
+	}
+
+	expected := "created grep\n" +
+		"warning: grep: " + filepath.Join(pathDir, "grep") + " is also on PATH\n" +
+		"conflict irb: " + filepath.Join(shimDir, "irb") + " exists and is not a symlink\n" +
+		"conflict ruby: " + filepath.Join(shimDir, "ruby") + " is a link to /usr/bin/ruby\n" +
+		"updated samtools, was link to " + oldHelper + "\n" +
+		"removed blast\n" +
+		"removed julia\n"
+	if stdout.String() != expected {
+		t.Errorf("install shims reported:\n%s\nexpected:\n%s", stdout.String(), expected)
+	}
+
+	for name, expected := range map[string]string{
+		"grep":     helper,
+		"ruby":     "/usr/bin/ruby",
+		"samtools": helper,
+		"perl":     "/usr/bin/perl",
+	} {
+		target, err := os.Readlink(filepath.Join(shimDir, name))
+		if err != nil || target != expected {
+			t.Errorf("shim %s links to %s (%v), expected %s", name, target, err, expected)
+		}
+	}
+	for _, name := range []string{"blast", "julia", "samtools@1.4.1"} {
+		if exists(filepath.Join(shimDir, name)) {
+			t.Errorf("unexpected shim %s", name)
+		}
+	}
+}
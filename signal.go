@@ -0,0 +1,91 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const DefaultSignalGracePeriod = 10 * time.Second
+
+// ForwardedSignals are passed on to the containerised command
+var ForwardedSignals = []os.Signal{syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// isTerminating returns whether the signal should result in the
+// command being killed, if it doesn't exit of its own accord
+func isTerminating(s os.Signal) bool {
+	return s == syscall.SIGTERM || s == syscall.SIGHUP
+}
+
+// setActive records the command to which signals are forwarded, if any
+func (r *RunnerT) setActive(c *CommandT) {
+	r.activeMutex.Lock()
+	defer r.activeMutex.Unlock()
+	r.active = c
+}
+
+func (r *RunnerT) activeCommand() *CommandT {
+	r.activeMutex.Lock()
+	defer r.activeMutex.Unlock()
+	return r.active
+}
+
+func (r *RunnerT) signalGracePeriod() time.Duration {
+	if r.config.SignalGracePeriod.Duration == 0 {
+		return DefaultSignalGracePeriod
+	}
+	return r.config.SignalGracePeriod.Duration
+}
+
+// HandleSignals forwards signals to the running command.  After a
+// terminating signal, the command is killed if it has not exited by
+// the end of the grace period, whereupon Execute cleans up as usual.
+// If there is no running command, we simply clean up, write the audit
+// record, and exit.
+func (r *RunnerT) HandleSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, append([]os.Signal{syscall.SIGINT}, ForwardedSignals...)...)
+	go func() {
+		var killTimer *time.Timer
+		for s := range c {
+			// We can't simply signal.Ignore(syscall.SIGINT), as that would
+			// inhibit child processes from receiving it, so we disregard it here.
+			if s == syscall.SIGINT {
+				continue
+			}
+
+			cmd := r.activeCommand()
+			if cmd == nil {
+				if isTerminating(s) {
+					r.RemoveTempFiles()
+					err := &SignalError{s.(syscall.Signal)}
+					r.finish(err)
+					os.Exit(ExitStatus(err))
+				}
+				continue
+			}
+
+			WarnOnFailure(cmd.Signal(s))
+			if isTerminating(s) && killTimer == nil {
+				killTimer = time.AfterFunc(r.signalGracePeriod(), func() {
+					WarnOnFailure(cmd.Signal(syscall.SIGKILL))
+				})
+			}
+		}
+	}()
+}
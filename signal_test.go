@@ -0,0 +1,151 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tesujimath/rktrunner/internal/fakerkt"
+)
+
+// awaitFile waits for the file to exist with at least n lines
+func (h *harness) awaitFile(path string, n int) {
+	for i := 0; i < 1000; i++ {
+		content, err := ioutil.ReadFile(path)
+		if err == nil && strings.Count(string(content), "\n") >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.t.Fatalf("timed out waiting for %s", path)
+}
+
+// signalRktRun runs rkt-run while the fake rkt subcommand hangs,
+// ignoring the signals in ignore, and sends it each signal in turn.
+// It returns the exit status, and the signals received by the fake rkt.
+func (h *harness) signalRktRun(subcommand string, ignore []syscall.Signal, signals []syscall.Signal, args ...string) (int, []syscall.Signal) {
+	var hang string
+	for _, s := range ignore {
+		hang += fmt.Sprintf("%d\n", int(s))
+	}
+	err := ioutil.WriteFile(filepath.Join(h.rktDir, subcommand+".hang"), []byte(hang), 0644)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	cmd, stderr := h.rktRunCommand(args...)
+	err = cmd.Start()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	h.awaitFile(filepath.Join(h.rktDir, subcommand+".ready"), 0)
+	for i, s := range signals {
+		err = cmd.Process.Signal(s)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		if i < len(signals)-1 {
+			h.awaitFile(filepath.Join(h.rktDir, fakerkt.SignalLog), i+1)
+		}
+	}
+
+	status := 0
+	err = cmd.Wait()
+	if err != nil {
+		exitErr, isExitErr := err.(*exec.ExitError)
+		if !isExitErr {
+			h.t.Fatal(err)
+		}
+		status = exitErr.ExitCode()
+	}
+	if strings.Contains(stderr.String(), "warning") {
+		h.t.Errorf("unexpected warning: %s", stderr.String())
+	}
+
+	lines, err := readLines(filepath.Join(h.rktDir, fakerkt.SignalLog))
+	if err != nil && !os.IsNotExist(err) {
+		h.t.Fatal(err)
+	}
+	var received []syscall.Signal
+	for _, line := range lines {
+		var n int
+		fmt.Sscanf(line, "%d", &n)
+		received = append(received, syscall.Signal(n))
+	}
+	return status, received
+}
+
+func TestSignalForwarding(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testConfig)
+
+	status, received := h.signalRktRun("run", nil, []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP}, "grep", "needle")
+	if expected := 128 + int(syscall.SIGHUP); status != expected {
+		t.Errorf("exit status %d, expected %d", status, expected)
+	}
+	if expected := []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("fake rkt received %v, expected %v", received, expected)
+	}
+	entries, err := ioutil.ReadDir(h.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unexpected files left in %s: %v", h.root, entries)
+	}
+}
+
+func TestSignalForwardingEnter(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(testWorkerConfig)
+	h.script("status", "state=running\n", 0)
+
+	status, received := h.signalRktRun("enter", nil, []syscall.Signal{syscall.SIGTERM}, "grep", "needle")
+	if expected := 128 + int(syscall.SIGTERM); status != expected {
+		t.Errorf("exit status %d, expected %d", status, expected)
+	}
+	if expected := []syscall.Signal{syscall.SIGTERM}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("fake rkt received %v, expected %v", received, expected)
+	}
+}
+
+func TestSignalGracePeriod(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig("signal-grace-period = \"100ms\"\n" + testConfig)
+
+	status, received := h.signalRktRun("run", []syscall.Signal{syscall.SIGTERM}, []syscall.Signal{syscall.SIGTERM}, "grep", "needle")
+	if expected := 128 + int(syscall.SIGKILL); status != expected {
+		t.Errorf("exit status %d, expected %d", status, expected)
+	}
+	if expected := []syscall.Signal{syscall.SIGTERM}; !reflect.DeepEqual(received, expected) {
+		t.Errorf("fake rkt received %v, expected %v", received, expected)
+	}
+	entries, err := ioutil.ReadDir(h.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unexpected files left in %s: %v", h.root, entries)
+	}
+}
@@ -21,7 +21,7 @@ import (
 
 func Warnf(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, "warning: ")
-	fmt.Fprintf(os.Stderr, format, args)
+	fmt.Fprintf(os.Stderr, format, args...)
 	fmt.Fprintf(os.Stderr, "\n")
 }
 
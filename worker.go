@@ -15,48 +15,51 @@
 package rktrunner
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"os/user"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
-
-	"github.com/appc/spec/schema"
 )
 
 const WORKER_APPNAME_PREFIX = "rktrunner-"
 
+// errPodSaturated is returned when all the session slots of a worker
+// pod are in use.
+var errPodSaturated = errors.New("all sessions in use")
+
 type Worker struct {
-	rkt     string
-	uid     int
-	image   string
-	verbose bool
-	AppName string
-	UUID    string
-	Podlock *os.File
+	runtime     Runtime
+	uid         int
+	image       string
+	fingerprint string
+	maxSessions int
+	verbose     bool
+	AppName     string
+	UUID        string
+	Podlock     *os.File
+	Sessionlock *os.File
+	pod         *PodInfo // as found by the runtime, see hostPath
 }
 
-func NewWorker(u *user.User, image, rkt string, verbose bool) (*Worker, error) {
+// NewWorker finds and locks a worker pod for the user and image, if
+// there is one started with the same fingerprint and with a free session,
+// where maxSessions of zero means unlimited sessions per pod.
+func NewWorker(u *user.User, image, fingerprint string, runtime Runtime, maxSessions int, verbose bool) (*Worker, error) {
 	var err error
-	w := &Worker{rkt: rkt, verbose: verbose}
+	w := &Worker{runtime: runtime, fingerprint: fingerprint, maxSessions: maxSessions, verbose: verbose}
 
 	w.uid, err = strconv.Atoi(u.Uid)
 	if err != nil {
 		return nil, err
 	}
 
-	// need version suffix on image name, to match output of rkt list
-	if strings.ContainsRune(image, ':') {
-		w.image = image
-	} else {
-		w.image = fmt.Sprintf("%s:latest", image)
-	}
+	// canonical image name, to match output of rkt list
+	w.image = CanonicalImageName(image)
 
 	w.AppName = fmt.Sprintf("%s%s", WORKER_APPNAME_PREFIX, u.Username)
 
@@ -82,44 +85,15 @@ func (w *Worker) FoundPod() bool {
 func (w *Worker) awaitReady(uuid string) error {
 	ready := false
 	for !ready {
-		cmd := exec.Command("rkt", "status", uuid)
-		cmd.Path = w.rkt
-		stdout, err := cmd.StdoutPipe()
+		state, err := w.runtime.Status(uuid)
 		if err != nil {
-			return err
-		}
-		err = cmd.Start()
-		if err != nil {
-			return fmt.Errorf("%s %s %s failed to start: ", w.rkt, "status", uuid, err)
-		}
-
-		foundState := false
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() && !foundState {
-			fields := strings.SplitN(scanner.Text(), "=", 2)
-			if len(fields) == 2 && fields[0] == "state" {
-				foundState = true
-				if fields[1] == "running" || fields[1] == "exited" {
-					ready = true
-				}
-			}
-		}
-		if !foundState && w.verbose {
-			Warnf("rkt status %s failed to list state", uuid)
-		}
-
-		warn := cmd.Wait()
-		if warn != nil {
-			// Simply warn about rkt status failure, since it does fail if
+			// Simply warn about status failure, since it does fail if
 			// we call it too early.  And retry.
 			if w.verbose {
-				Warnf("rkt status %s failed: %v, retry", uuid, warn)
+				Warnf("%s status %s failed: %v, retry", w.runtime.Name(), uuid, err)
 			}
-			ready = false
-		}
-		err = scanner.Err()
-		if err != nil {
-			return err
+		} else if state == "running" || state == "exited" {
+			ready = true
 		}
 		if !ready {
 			// not yet ready, so pause before retry
@@ -132,7 +106,8 @@ func (w *Worker) awaitReady(uuid string) error {
 	return nil
 }
 
-// LockPod attempts to acquire a shared lock on the pod, without blocking.
+// LockPod attempts to acquire a shared lock on the pod, and a session
+// slot if sessions are limited, without blocking.
 func (w *Worker) LockPod(uuid string) error {
 	podlock, err := os.Open(WorkerPodDir(uuid))
 	if err != nil {
@@ -143,11 +118,79 @@ func (w *Worker) LockPod(uuid string) error {
 		podlock.Close()
 		return err
 	}
+	var sessionlock *os.File
+	if w.maxSessions > 0 {
+		sessionlock, err = lockSession(uuid, w.maxSessions)
+		if err != nil {
+			podlock.Close()
+			return err
+		}
+	}
 	w.UUID = uuid
 	w.Podlock = podlock
+	w.Sessionlock = sessionlock
+	w.WarnOnFailureIfVerbose(writeTimestamp(workerLastUsePath(uuid)))
 	return nil
 }
 
+// lockSession acquires an exclusive lock on the first free session slot
+// of the pod, without blocking, failing with errPodSaturated if there is
+// none.
+func lockSession(uuid string, maxSessions int) (*os.File, error) {
+	for slot := 0; slot < maxSessions; slot++ {
+		sessionlock, err := os.OpenFile(workerSessionPath(uuid, slot), os.O_RDONLY|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = syscall.Flock(int(sessionlock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return sessionlock, nil
+		}
+		sessionlock.Close()
+		if err != syscall.EAGAIN {
+			return nil, err
+		}
+	}
+	return nil, errPodSaturated
+}
+
+// Release records the end of our session, and releases our locks on the
+// pod, so it may be garbage collected when no longer in use.
+func (w *Worker) Release() {
+	if w.Podlock != nil {
+		w.WarnOnFailureIfVerbose(writeTimestamp(workerSessionEndPath(w.UUID)))
+	}
+	if w.Sessionlock != nil {
+		w.Sessionlock.Close()
+		w.Sessionlock = nil
+	}
+	if w.Podlock != nil {
+		w.Podlock.Close()
+		w.Podlock = nil
+	}
+}
+
+// StopIfUnused releases our lock on the pod, and stops it if
+// there are no other users.
+func (w *Worker) StopIfUnused() error {
+	w.Release()
+	podlock, err := lockPodExclusive(w.UUID)
+	if err != nil {
+		if err == syscall.EAGAIN {
+			// busy
+			return nil
+		}
+		return err
+	}
+	defer podlock.Close()
+
+	err = w.runtime.Stop(w.UUID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(WorkerPodDir(w.UUID))
+}
+
 // InitializePod sets up a new pod for use as a worker, and locks it.
 func (w *Worker) InitializePod(uuidPath string, cmdWaiter chan error) error {
 	// wait for the UUID file, or the cmd itself to finish (e.g. on failure)
@@ -188,58 +231,60 @@ func (w *Worker) InitializePod(uuidPath string, cmdWaiter chan error) error {
 		return err
 	}
 
-	return w.LockPod(uuid)
-}
-
-func (w *Worker) verifyPodUser(uuid string) error {
-	cmd := exec.Command("rkt", "cat-manifest", uuid)
-	stdout, err := cmd.StdoutPipe()
+	err = w.writeFingerprint(uuid)
 	if err != nil {
 		return err
 	}
 
-	err = cmd.Start()
-	if err != nil {
-		return err
-	}
+	return w.LockPod(uuid)
+}
 
-	manifest := json.NewDecoder(stdout)
-	var pm schema.PodManifest
-	err = manifest.Decode(&pm)
-	errWait := cmd.Wait()
+func (w *Worker) verifyPodUser(uuid string) error {
+	pod, err := w.runtime.Pod(uuid)
 	if err != nil {
 		return err
 	}
-	if errWait != nil {
-		return errWait
-	}
 
-	if len(pm.Apps) != 1 {
-		return fmt.Errorf("unexpected pod manifest with %d apps", len(pm.Apps))
+	if len(pod.Apps) != 1 {
+		return fmt.Errorf("unexpected pod manifest with %d apps", len(pod.Apps))
 	}
-	ra := pm.Apps[0]
+	app := pod.Apps[0]
 
-	if ra.App.User != strconv.Itoa(w.uid) {
-		return fmt.Errorf("unexpected pod manifest user %s, expected %d", ra.App.User, w.uid)
+	if app.User != strconv.Itoa(w.uid) {
+		return fmt.Errorf("unexpected pod manifest user %s, expected %d", app.User, w.uid)
 	}
 
+	w.pod = pod
 	return nil
 }
 
 // findPod finds the UUID for a worker pod, if any
 func (w *Worker) findPod() {
-	imageName := CanonicalImageName(w.image)
-	w.WarnOnFailureIfVerbose(VisitPods(func(pod *VisitedPod) bool {
+	w.WarnOnFailureIfVerbose(w.runtime.VisitPods(func(pod *VisitedPod) bool {
 		if pod.AppName == w.AppName && pod.State == "running" {
-			if pod.Image == imageName {
-				err := w.verifyPodUser(pod.UUID)
+			if pod.Image == w.image {
+				err := w.checkFingerprint(pod.UUID)
+				if err == nil {
+					err = w.verifyPodUser(pod.UUID)
+				}
 				if err == nil {
 					err = w.LockPod(pod.UUID)
 				}
-				w.WarnOnFailureIfVerbose(err)
+				switch err {
+				case errFingerprintMismatch:
+					if w.verbose {
+						fmt.Fprintf(os.Stderr, "ignoring pod %s, started with other volumes, options or environment\n", pod.UUID)
+					}
+				case errPodSaturated:
+					if w.verbose {
+						fmt.Fprintf(os.Stderr, "ignoring pod %s, with %d sessions\n", pod.UUID, w.maxSessions)
+					}
+				default:
+					w.WarnOnFailureIfVerbose(err)
+				}
 			} else {
 				if w.verbose {
-					fmt.Fprintf(os.Stderr, "ignoring pod for %s, is not %s\n", pod.Image, imageName)
+					fmt.Fprintf(os.Stderr, "ignoring pod for %s, is not %s\n", pod.Image, w.image)
 				}
 			}
 		}
@@ -247,8 +292,21 @@ func (w *Worker) findPod() {
 	}))
 }
 
-func (w *Worker) hostPath(podPath string) string {
-	return fmt.Sprintf("/var/lib/rkt/pods/run/%s/stage1/rootfs/opt/stage2/%s/rootfs%s", w.UUID, w.AppName, podPath)
+// hostPath returns the host path of a path within the worker app
+func (w *Worker) hostPath(podPath string) (string, error) {
+	if w.pod == nil || w.pod.UUID != w.UUID {
+		pod, err := w.runtime.Pod(w.UUID)
+		if err != nil {
+			return "", err
+		}
+		w.pod = pod
+	}
+	for _, app := range w.pod.Apps {
+		if app.Name == w.AppName {
+			return app.Rootfs + podPath, nil
+		}
+	}
+	return "", fmt.Errorf("no app %s in pod %s", w.AppName, w.UUID)
 }
 
 func (w *Worker) setTimezoneFromHost() error {
@@ -256,7 +314,10 @@ func (w *Worker) setTimezoneFromHost() error {
 		fmt.Fprintf(os.Stderr, "setting timezone from host\n")
 	}
 	timezone := "/etc/localtime"
-	podTimezone := w.hostPath(timezone)
+	podTimezone, err := w.hostPath(timezone)
+	if err != nil {
+		return err
+	}
 	tz, err := ioutil.ReadFile(timezone)
 	if err != nil {
 		return err
@@ -274,7 +335,11 @@ func (w *Worker) appendPasswdEntries(passwd []string) error {
 	if w.verbose {
 		fmt.Fprintf(os.Stderr, "appending to passwd file: %s\n", strings.Join(passwd, ", "))
 	}
-	f, err := os.OpenFile(w.hostPath("/etc/passwd"), os.O_WRONLY|os.O_APPEND, 0644)
+	path, err := w.hostPath("/etc/passwd")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return err
 	}
@@ -290,7 +355,11 @@ func (w *Worker) appendGroupEntries(group []string) error {
 	if w.verbose {
 		fmt.Fprintf(os.Stderr, "appending to group file: %s\n", strings.Join(group, ", "))
 	}
-	f, err := os.OpenFile(w.hostPath("/etc/group"), os.O_WRONLY|os.O_APPEND, 0644)
+	path, err := w.hostPath("/etc/group")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		return err
 	}
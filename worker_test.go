@@ -0,0 +1,220 @@
+// Copyright 2017 The rktrunner Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rktrunner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/tesujimath/rktrunner/internal/fakerkt"
+)
+
+// setMasterRoot points the worker pod dirs at the harness, until the test ends.
+func (h *harness) setMasterRoot() {
+	saved := masterRoot
+	masterRoot = h.root
+	h.t.Cleanup(func() { masterRoot = saved })
+}
+
+const testFingerprint = "0123456789abcdef"
+
+// createWorkerPodDir creates the dir for a worker pod started with testFingerprint.
+func (h *harness) createWorkerPodDir(uuid string) {
+	h.createWorkerPodDirWithFingerprint(uuid, testFingerprint)
+}
+
+// workerFingerprint returns the fingerprint of the worker pod which
+// rkt-run would start for the request, leaving no fake rkt invocations.
+func (h *harness) workerFingerprint(req RunRequest) string {
+	r, err := NewRunnerFromRequest(&req, h.user, h.config)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	err = os.Remove(filepath.Join(h.rktDir, fakerkt.ArgvLog))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return r.worker.fingerprint
+}
+
+func (h *harness) createWorkerPodDirWithFingerprint(uuid, fingerprint string) {
+	err := os.Mkdir(filepath.Join(h.root, podPrefix+uuid), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(h.root, podPrefix+uuid, "fingerprint"), []byte(fingerprint+"\n"), 0644)
+	}
+	if err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func TestFindPod(t *testing.T) {
+	const otherUUID = "11111111-1111-1111-1111-111111111111"
+	const image = "example.com/tools/busybox:1.0"
+
+	tests := []struct {
+		name     string
+		list     string
+		uid      string
+		expected string
+	}{
+		{"none", "", "", ""},
+		{"match", listLine(fakeUUID, "rktrunner-$USER", image, "running", ""), "$UID", fakeUUID},
+		{"exited", listLine(fakeUUID, "rktrunner-$USER", image, "exited", ""), "$UID", ""},
+		{"other image", listLine(fakeUUID, "rktrunner-$USER", "example.com/tools/busybox:1.1", "running", ""), "$UID", ""},
+		{"other user", listLine(fakeUUID, "rktrunner-someone-else", image, "running", ""), "$UID", ""},
+		{"wrong uid", listLine(fakeUUID, "rktrunner-$USER", image, "running", ""), "54321", ""},
+		{"second", listLine(otherUUID, "rktrunner-$USER", "example.com/tools/busybox:1.1", "running", "") + listLine(fakeUUID, "rktrunner-$USER", image, "running", ""), "$UID", fakeUUID},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.setMasterRoot()
+			h.createWorkerPodDir(fakeUUID)
+			h.createWorkerPodDir(otherUUID)
+			h.script("list", h.denormalize(test.list), 0)
+			h.script("cat-manifest", podManifest(h.denormalize(test.uid)), 0)
+
+			w, err := NewWorker(h.user, image, testFingerprint, NewRktRuntime(h.rkt), 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.UUID != test.expected {
+				t.Errorf("found pod %q, expected %q", w.UUID, test.expected)
+			}
+			if w.FoundPod() != (test.expected != "") {
+				t.Errorf("FoundPod() returned %v", w.FoundPod())
+			}
+			if w.Podlock != nil {
+				w.Podlock.Close()
+			}
+		})
+	}
+}
+
+// lockSessions holds the first n session slots of the pod, until the test ends.
+func (h *harness) lockSessions(uuid string, n int) {
+	for slot := 0; slot < n; slot++ {
+		f, err := os.OpenFile(workerSessionPath(uuid, slot), os.O_RDONLY|os.O_CREATE, 0644)
+		if err == nil {
+			err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		}
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		h.t.Cleanup(func() { f.Close() })
+	}
+}
+
+func TestFindPodMaxSessions(t *testing.T) {
+	const otherUUID = "11111111-1111-1111-1111-111111111111"
+	const image = "example.com/tools/busybox:1.0"
+
+	tests := []struct {
+		name        string
+		maxSessions int
+		inUse       int
+		expected    string
+		slot        string
+	}{
+		{"unlimited", 0, 3, fakeUUID, ""},
+		{"free", 2, 0, fakeUUID, "session-0"},
+		{"one free", 2, 1, fakeUUID, "session-1"},
+		{"saturated", 2, 2, otherUUID, "session-0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newHarness(t)
+			h.setMasterRoot()
+			h.createWorkerPodDir(fakeUUID)
+			h.createWorkerPodDir(otherUUID)
+			h.lockSessions(fakeUUID, test.inUse)
+			h.script("list", h.denormalize(
+				listLine(fakeUUID, "rktrunner-$USER", image, "running", "")+
+					listLine(otherUUID, "rktrunner-$USER", image, "running", "")), 0)
+			h.script("cat-manifest", podManifest(h.user.Uid), 0)
+
+			w, err := NewWorker(h.user, image, testFingerprint, NewRktRuntime(h.rkt), test.maxSessions, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Release()
+			if w.UUID != test.expected {
+				t.Errorf("found pod %q, expected %q", w.UUID, test.expected)
+			}
+			var slot string
+			if w.Sessionlock != nil {
+				slot = filepath.Base(w.Sessionlock.Name())
+			}
+			if slot != test.slot {
+				t.Errorf("locked session %q, expected %q", slot, test.slot)
+			}
+		})
+	}
+}
+
+func TestFindPodAllSaturated(t *testing.T) {
+	const image = "example.com/tools/busybox:1.0"
+	h := newHarness(t)
+	h.setMasterRoot()
+	h.createWorkerPodDir(fakeUUID)
+	h.lockSessions(fakeUUID, 1)
+	h.script("list", h.denormalize(listLine(fakeUUID, "rktrunner-$USER", image, "running", "")), 0)
+	h.script("cat-manifest", podManifest(h.user.Uid), 0)
+
+	w, err := NewWorker(h.user, image, testFingerprint, NewRktRuntime(h.rkt), 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.FoundPod() || w.Podlock != nil {
+		t.Errorf("unexpectedly found saturated pod %s", w.UUID)
+	}
+}
+
+func TestFindPodFingerprint(t *testing.T) {
+	const otherUUID = "11111111-1111-1111-1111-111111111111"
+	const image = "example.com/tools/busybox:1.0"
+	h := newHarness(t)
+	h.setMasterRoot()
+	h.createWorkerPodDirWithFingerprint(otherUUID, "fedcba9876543210")
+	err := os.Mkdir(filepath.Join(h.root, podPrefix+"22222222-2222-2222-2222-222222222222"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.createWorkerPodDir(fakeUUID)
+	h.script("list", h.denormalize(
+		listLine(otherUUID, "rktrunner-$USER", image, "running", "")+
+			listLine("22222222-2222-2222-2222-222222222222", "rktrunner-$USER", image, "running", "")+
+			listLine(fakeUUID, "rktrunner-$USER", image, "running", "")), 0)
+	h.script("cat-manifest", podManifest(h.user.Uid), 0)
+
+	w, err := NewWorker(h.user, image, testFingerprint, NewRktRuntime(h.rkt), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Release()
+	if w.UUID != fakeUUID {
+		t.Errorf("found pod %q, expected %q", w.UUID, fakeUUID)
+	}
+	// the manifest is checked only for the pod with matching fingerprint
+	h.expectInvocations(
+		[]string{"rkt", "list", "--full", "--no-legend"},
+		[]string{"rkt", "cat-manifest", fakeUUID},
+	)
+}